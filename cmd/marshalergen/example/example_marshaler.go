@@ -0,0 +1,394 @@
+// Code generated by marshalergen. DO NOT EDIT.
+
+package example
+
+import (
+	"strconv"
+	"time"
+
+	marshaler "github.com/djthorpe/go-marshaler"
+)
+
+// MarshalerTag returns the tag name used by DecodeMap and EncodeMap
+func (v Base) MarshalerTag() string {
+	return "yaml"
+}
+
+// MarshalerType returns a nil pointer to Base, so that DecodeMap and EncodeMap
+// are not used for structures which embed it
+func (v Base) MarshalerType() interface{} {
+	return (*Base)(nil)
+}
+
+// DecodeMap decodes a map into Base, with the same semantics as marshaler.Decoder
+func (v *Base) DecodeMap(dec *marshaler.Decoder, src map[string]interface{}) error {
+	var result marshaler.Errors
+	if value, exists := src["id"]; exists {
+		if x, ok := value.(int); ok && dec.PassThrough() {
+			v.ID = x
+		} else if err := dec.DecodeValue("id", value, &v.ID); err != nil {
+			result = result.Append(err)
+		}
+	} else if err := dec.RequiredError("id", &v.ID); err != nil {
+		result = result.Append(err)
+	}
+	if value, exists := src["created"]; exists {
+		if x, ok := value.(time.Time); ok && dec.PassThrough() {
+			v.Created = x
+		} else if err := dec.DecodeValue("created", value, &v.Created); err != nil {
+			result = result.Append(err)
+		}
+	}
+	if len(result) > 0 {
+		return result
+	}
+	return nil
+}
+
+// EncodeMap encodes Base into a map, with the same semantics as marshaler.Encoder
+func (v Base) EncodeMap(enc *marshaler.Encoder) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, 2)
+	if enc.PassThrough() {
+		result["id"] = v.ID
+	} else if value, err := enc.EncodeValue(v.ID); err != nil {
+		return nil, err
+	} else {
+		result["id"] = value
+	}
+	if enc.PassThrough() {
+		result["created"] = v.Created
+	} else if value, err := enc.EncodeValue(v.Created); err != nil {
+		return nil, err
+	} else {
+		result["created"] = value
+	}
+	return result, nil
+}
+
+// MarshalerTag returns the tag name used by DecodeMap and EncodeMap
+func (v Config) MarshalerTag() string {
+	return "yaml"
+}
+
+// MarshalerType returns a nil pointer to Config, so that DecodeMap and EncodeMap
+// are not used for structures which embed it
+func (v Config) MarshalerType() interface{} {
+	return (*Config)(nil)
+}
+
+// DecodeMap decodes a map into Config, with the same semantics as marshaler.Decoder
+func (v *Config) DecodeMap(dec *marshaler.Decoder, src map[string]interface{}) error {
+	var result marshaler.Errors
+	if value, exists := src["servers"]; exists {
+		if s, ok := value.([]interface{}); ok && dec.PassThrough() {
+			v.Servers = make([]Server, len(s))
+			for i1, value1 := range s {
+				if m, ok := value1.(map[string]interface{}); ok {
+					if err := dec.DecodeMap("servers["+strconv.Itoa(i1)+"]", m, &v.Servers[i1]); err != nil {
+						result = result.Append(err)
+					}
+				} else if err := dec.DecodeValue("servers["+strconv.Itoa(i1)+"]", value1, &v.Servers[i1]); err != nil {
+					result = result.Append(err)
+				}
+			}
+		} else if err := dec.DecodeValue("servers", value, &v.Servers); err != nil {
+			result = result.Append(err)
+		}
+	}
+	if value, exists := src["primary"]; exists {
+		if m, ok := value.(map[string]interface{}); ok && dec.PassThrough() {
+			if v.Primary == nil {
+				v.Primary = new(Server)
+			}
+			if err := dec.DecodeMap("primary", m, v.Primary); err != nil {
+				result = result.Append(err)
+			}
+		} else if err := dec.DecodeValue("primary", value, &v.Primary); err != nil {
+			result = result.Append(err)
+		}
+	}
+	if value, exists := src["weight"]; exists {
+		if x, ok := value.(float64); ok && dec.PassThrough() {
+			v.Weight = x
+		} else if err := dec.DecodeValue("weight", value, &v.Weight); err != nil {
+			result = result.Append(err)
+		}
+	}
+	if len(result) > 0 {
+		return result
+	}
+	return nil
+}
+
+// EncodeMap encodes Config into a map, with the same semantics as marshaler.Encoder
+func (v Config) EncodeMap(enc *marshaler.Encoder) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, 3)
+	if enc.PassThrough() {
+		if v.Servers == nil {
+			result["servers"] = nil
+		} else {
+			x := make([]interface{}, len(v.Servers))
+			for i := range v.Servers {
+				if m, err := v.Servers[i].EncodeMap(enc); err != nil {
+					return nil, err
+				} else {
+					x[i] = m
+				}
+			}
+			result["servers"] = x
+		}
+	} else if value, err := enc.EncodeValue(v.Servers); err != nil {
+		return nil, err
+	} else {
+		result["servers"] = value
+	}
+	if v.Primary != nil {
+		if enc.PassThrough() {
+			if m, err := v.Primary.EncodeMap(enc); err != nil {
+				return nil, err
+			} else {
+				result["primary"] = m
+			}
+		} else if value, err := enc.EncodeValue(v.Primary); err != nil {
+			return nil, err
+		} else {
+			result["primary"] = value
+		}
+	}
+	if enc.PassThrough() {
+		result["weight"] = v.Weight
+	} else if value, err := enc.EncodeValue(v.Weight); err != nil {
+		return nil, err
+	} else {
+		result["weight"] = value
+	}
+	return result, nil
+}
+
+// MarshalerTag returns the tag name used by DecodeMap and EncodeMap
+func (v Meta) MarshalerTag() string {
+	return "yaml"
+}
+
+// MarshalerType returns a nil pointer to Meta, so that DecodeMap and EncodeMap
+// are not used for structures which embed it
+func (v Meta) MarshalerType() interface{} {
+	return (*Meta)(nil)
+}
+
+// DecodeMap decodes a map into Meta, with the same semantics as marshaler.Decoder
+func (v *Meta) DecodeMap(dec *marshaler.Decoder, src map[string]interface{}) error {
+	var result marshaler.Errors
+	if value, exists := src["labels"]; exists {
+		if m, ok := value.(map[string]interface{}); ok && dec.PassThrough() {
+			v.Labels = make(map[string]string, len(m))
+			for key1, value1 := range m {
+				var elem1 string
+				n1 := len(result)
+				if x, ok := value1.(string); ok {
+					elem1 = x
+				} else if err := dec.DecodeValue("labels."+key1, value1, &elem1); err != nil {
+					result = result.Append(err)
+				}
+				if len(result) == n1 {
+					v.Labels[key1] = elem1
+				}
+			}
+		} else if err := dec.DecodeValue("labels", value, &v.Labels); err != nil {
+			result = result.Append(err)
+		}
+	}
+	if len(result) > 0 {
+		return result
+	}
+	return nil
+}
+
+// EncodeMap encodes Meta into a map, with the same semantics as marshaler.Encoder
+func (v Meta) EncodeMap(enc *marshaler.Encoder) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, 1)
+	if v.Labels != nil {
+		if enc.PassThrough() {
+			result["labels"] = v.Labels
+		} else if value, err := enc.EncodeValue(v.Labels); err != nil {
+			return nil, err
+		} else {
+			result["labels"] = value
+		}
+	}
+	return result, nil
+}
+
+// MarshalerTag returns the tag name used by DecodeMap and EncodeMap
+func (v Server) MarshalerTag() string {
+	return "yaml"
+}
+
+// MarshalerType returns a nil pointer to Server, so that DecodeMap and EncodeMap
+// are not used for structures which embed it
+func (v Server) MarshalerType() interface{} {
+	return (*Server)(nil)
+}
+
+// DecodeMap decodes a map into Server, with the same semantics as marshaler.Decoder
+func (v *Server) DecodeMap(dec *marshaler.Decoder, src map[string]interface{}) error {
+	var result marshaler.Errors
+	if value, exists := src["id"]; exists {
+		if x, ok := value.(int); ok && dec.PassThrough() {
+			v.Base.ID = x
+		} else if err := dec.DecodeValue("id", value, &v.Base.ID); err != nil {
+			result = result.Append(err)
+		}
+	} else if err := dec.RequiredError("id", &v.Base.ID); err != nil {
+		result = result.Append(err)
+	}
+	if value, exists := src["created"]; exists {
+		if x, ok := value.(time.Time); ok && dec.PassThrough() {
+			v.Base.Created = x
+		} else if err := dec.DecodeValue("created", value, &v.Base.Created); err != nil {
+			result = result.Append(err)
+		}
+	}
+	if value, exists := src["labels"]; exists {
+		if v.Meta == nil {
+			v.Meta = new(Meta)
+		}
+		if m, ok := value.(map[string]interface{}); ok && dec.PassThrough() {
+			v.Meta.Labels = make(map[string]string, len(m))
+			for key1, value1 := range m {
+				var elem1 string
+				n1 := len(result)
+				if x, ok := value1.(string); ok {
+					elem1 = x
+				} else if err := dec.DecodeValue("labels."+key1, value1, &elem1); err != nil {
+					result = result.Append(err)
+				}
+				if len(result) == n1 {
+					v.Meta.Labels[key1] = elem1
+				}
+			}
+		} else if err := dec.DecodeValue("labels", value, &v.Meta.Labels); err != nil {
+			result = result.Append(err)
+		}
+	}
+	if value, exists := src["name"]; exists {
+		if x, ok := value.(string); ok && dec.PassThrough() {
+			v.Name = x
+		} else if err := dec.DecodeValue("name", value, &v.Name); err != nil {
+			result = result.Append(err)
+		}
+	}
+	if value, exists := src["port"]; exists {
+		if x, ok := value.(uint16); ok && dec.PassThrough() {
+			v.Port = x
+		} else if err := dec.DecodeValue("port", value, &v.Port); err != nil {
+			result = result.Append(err)
+		}
+	} else if err := dec.DecodeValue("port", "8080", &v.Port); err != nil {
+		result = result.Append(err)
+	}
+	if value, exists := src["timeout"]; exists {
+		if x, ok := value.(time.Duration); ok && dec.PassThrough() {
+			v.Timeout = x
+		} else if err := dec.DecodeValue("timeout", value, &v.Timeout); err != nil {
+			result = result.Append(err)
+		}
+	}
+	if value, exists := src["enabled"]; exists {
+		if x, ok := value.(bool); ok && dec.PassThrough() {
+			v.Enabled = x
+		} else if err := dec.DecodeValue("enabled", value, &v.Enabled); err != nil {
+			result = result.Append(err)
+		}
+	}
+	if value, exists := src["tags"]; exists {
+		if s, ok := value.([]interface{}); ok && dec.PassThrough() {
+			v.Tags = make([]string, len(s))
+			for i1, value1 := range s {
+				if x, ok := value1.(string); ok {
+					v.Tags[i1] = x
+				} else if err := dec.DecodeValue("tags["+strconv.Itoa(i1)+"]", value1, &v.Tags[i1]); err != nil {
+					result = result.Append(err)
+				}
+			}
+		} else if err := dec.DecodeValue("tags", value, &v.Tags); err != nil {
+			result = result.Append(err)
+		}
+	} else if err := dec.DecodeValue("tags", []string{"web", "api"}, &v.Tags); err != nil {
+		result = result.Append(err)
+	}
+	if len(result) > 0 {
+		return result
+	}
+	return nil
+}
+
+// EncodeMap encodes Server into a map, with the same semantics as marshaler.Encoder
+func (v Server) EncodeMap(enc *marshaler.Encoder) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, 8)
+	if enc.PassThrough() {
+		result["id"] = v.Base.ID
+	} else if value, err := enc.EncodeValue(v.Base.ID); err != nil {
+		return nil, err
+	} else {
+		result["id"] = value
+	}
+	if enc.PassThrough() {
+		result["created"] = v.Base.Created
+	} else if value, err := enc.EncodeValue(v.Base.Created); err != nil {
+		return nil, err
+	} else {
+		result["created"] = value
+	}
+	if v.Meta != nil {
+		if v.Meta.Labels != nil {
+			if enc.PassThrough() {
+				result["labels"] = v.Meta.Labels
+			} else if value, err := enc.EncodeValue(v.Meta.Labels); err != nil {
+				return nil, err
+			} else {
+				result["labels"] = value
+			}
+		}
+	}
+	if enc.PassThrough() {
+		result["name"] = v.Name
+	} else if value, err := enc.EncodeValue(v.Name); err != nil {
+		return nil, err
+	} else {
+		result["name"] = value
+	}
+	if enc.PassThrough() {
+		result["port"] = v.Port
+	} else if value, err := enc.EncodeValue(v.Port); err != nil {
+		return nil, err
+	} else {
+		result["port"] = value
+	}
+	if v.Timeout != 0 {
+		if enc.PassThrough() {
+			result["timeout"] = v.Timeout
+		} else if value, err := enc.EncodeValue(v.Timeout); err != nil {
+			return nil, err
+		} else {
+			result["timeout"] = value
+		}
+	}
+	if enc.PassThrough() {
+		result["enabled"] = v.Enabled
+	} else if value, err := enc.EncodeValue(v.Enabled); err != nil {
+		return nil, err
+	} else {
+		result["enabled"] = value
+	}
+	if len(v.Tags) != 0 {
+		if enc.PassThrough() {
+			result["tags"] = v.Tags
+		} else if value, err := enc.EncodeValue(v.Tags); err != nil {
+			return nil, err
+		} else {
+			result["tags"] = value
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,231 @@
+package example_test
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	marshaler "github.com/djthorpe/go-marshaler"
+	example "github.com/djthorpe/go-marshaler/cmd/marshalergen/example"
+)
+
+// plainServer has the same fields as Server without generated methods, so
+// is decoded and encoded with reflection
+type plainServer example.Server
+
+// plainConfig has the same fields as Config with plain servers
+type plainConfig struct {
+	Servers []plainServer `yaml:"servers"`
+	Primary *plainServer  `yaml:"primary,omitnil"`
+	Weight  float64       `yaml:"weight"`
+}
+
+func plainServers(servers []example.Server) []plainServer {
+	result := make([]plainServer, len(servers))
+	for i, server := range servers {
+		result[i] = plainServer(server)
+	}
+	return result
+}
+
+func Test_Example_001(t *testing.T) {
+	// Generated and reflective decoding should be the same
+	src := map[string]interface{}{
+		"id":      42,
+		"created": "2020-01-02T03:04:05Z",
+		"labels":  map[string]interface{}{"env": "prod"},
+		"name":    "server",
+		"timeout": "5s",
+		"enabled": true,
+	}
+	var generated example.Server
+	var plain plainServer
+	if err := marshaler.NewDecoder("yaml", marshaler.ConvertTime, marshaler.ConvertDuration, marshaler.ConvertIntUint, marshaler.ConvertStringToNumber).Decode(src, &generated); err != nil {
+		t.Fatal(err)
+	}
+	if err := marshaler.NewDecoder("yaml", marshaler.ConvertTime, marshaler.ConvertDuration, marshaler.ConvertIntUint, marshaler.ConvertStringToNumber).Decode(src, &plain); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(generated, example.Server(plain)) {
+		t.Errorf("Expected %+v, got %+v", plain, generated)
+	}
+	if generated.Port != 8080 || generated.Timeout != 5*time.Second || generated.Meta.Labels["env"] != "prod" || !reflect.DeepEqual(generated.Tags, []string{"web", "api"}) {
+		t.Errorf("Unexpected %+v", generated)
+	}
+}
+
+func Test_Example_002(t *testing.T) {
+	// Generated and reflective errors should be the same
+	src := map[string]interface{}{
+		"port": -1,
+		"name": []interface{}{"a"},
+	}
+	var generated example.Server
+	var plain plainServer
+	err1 := marshaler.NewDecoder("yaml", marshaler.ConvertTime, marshaler.ConvertDuration, marshaler.ConvertIntUint, marshaler.ConvertStringToNumber).Decode(src, &generated)
+	err2 := marshaler.NewDecoder("yaml", marshaler.ConvertTime, marshaler.ConvertDuration, marshaler.ConvertIntUint, marshaler.ConvertStringToNumber).Decode(src, &plain)
+	if err1 == nil || err2 == nil {
+		t.Fatal("Expected errors")
+	}
+	if err1.Error() != err2.Error() {
+		t.Errorf("Expected %q, got %q", err2, err1)
+	}
+	if !errors.Is(err1, marshaler.ErrRequired) || !errors.Is(err1, marshaler.ErrOutOfRange) {
+		t.Errorf("Unexpected %v", err1)
+	}
+}
+
+func Test_Example_003(t *testing.T) {
+	// Generated and reflective encoding should be the same
+	server := example.Server{
+		Base: example.Base{ID: 1, Created: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+		Name: "server",
+		Port: 80,
+	}
+	encoder := marshaler.NewEncoder("yaml")
+	generated, err := encoder.Encode(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := encoder.Encode(plainServer(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(generated, plain) {
+		t.Errorf("Expected %v, got %v", plain, generated)
+	}
+}
+
+func Test_Example_004(t *testing.T) {
+	// Nested structures use the generated methods
+	src := map[string]interface{}{
+		"servers": []interface{}{
+			map[string]interface{}{"id": 1, "port": "8000"},
+			map[string]interface{}{"port": 9000},
+		},
+		"weight": "0.5",
+	}
+	var config example.Config
+	err := marshaler.NewDecoder("yaml", marshaler.ConvertTime, marshaler.ConvertDuration, marshaler.ConvertIntUint, marshaler.ConvertStringToNumber).Decode(src, &config)
+	var fieldErr *marshaler.FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Path != "servers[1].id" {
+		t.Fatalf("Unexpected %v", err)
+	}
+	if len(config.Servers) != 2 || config.Servers[0].Port != 8000 || config.Weight != 0.5 {
+		t.Errorf("Unexpected %+v", config)
+	}
+}
+
+func Test_Example_005(t *testing.T) {
+	// The hooks and maximum number of errors of the calling decoder are used
+	src := map[string]interface{}{
+		"id":      1,
+		"port":    "8080",
+		"timeout": "5s",
+	}
+	for _, dec := range []*marshaler.Decoder{
+		marshaler.NewDecoder("yaml"),
+		marshaler.NewDecoder("yaml", marshaler.ConvertDuration),
+		marshaler.NewDecoder("yaml").SetMaxErrors(1),
+		marshaler.NewDecoder("yaml", marshaler.ConvertStringToNumber, marshaler.ConvertDuration),
+	} {
+		var generated example.Server
+		var plain plainServer
+		err1 := dec.Decode(src, &generated)
+		err2 := dec.Decode(src, &plain)
+		if fmt.Sprint(err1) != fmt.Sprint(err2) {
+			t.Errorf("Expected %v, got %v", err2, err1)
+		} else if !reflect.DeepEqual(generated, example.Server(plain)) {
+			t.Errorf("Expected %+v, got %+v", plain, generated)
+		}
+	}
+	var errs marshaler.Errors
+	if err := marshaler.NewDecoder("yaml").SetMaxErrors(1).Decode(src, &example.Server{}); !errors.As(err, &errs) || len(errs) != 1 {
+		t.Errorf("Unexpected %v", err)
+	}
+}
+
+func Test_Example_006(t *testing.T) {
+	// Nested structures, slices and maps are decoded and encoded directly,
+	// and are the same when decoded and encoded with reflection
+	src := map[string]interface{}{
+		"servers": []interface{}{
+			map[string]interface{}{"id": 1, "labels": map[string]interface{}{"a": "b"}},
+			map[string]interface{}{"id": 2, "timeout": time.Second},
+		},
+		"primary": map[string]interface{}{"id": 3, "created": time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+		"weight":  0.5,
+	}
+	var config example.Config
+	if err := marshaler.NewDecoder("yaml", marshaler.ConvertStringToNumber).Decode(src, &config); err != nil {
+		t.Fatal(err)
+	} else if len(config.Servers) != 2 || config.Servers[0].Meta.Labels["a"] != "b" || config.Servers[1].Timeout != time.Second || config.Primary.ID != 3 {
+		t.Errorf("Unexpected %+v", config)
+	}
+	for _, enc := range []*marshaler.Encoder{
+		marshaler.NewEncoder("yaml"),
+		marshaler.NewEncoder("yaml", marshaler.MarshalTime, marshaler.MarshalDuration),
+	} {
+		generated, err := enc.Encode(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, err := enc.Encode(plainConfig{plainServers(config.Servers), (*plainServer)(config.Primary), config.Weight})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(generated, plain) {
+			t.Errorf("Expected %v, got %v", plain, generated)
+		}
+	}
+}
+
+func Test_Example_007(t *testing.T) {
+	// Encoding errors are returned
+	fail := func(v reflect.Value) (reflect.Value, error) {
+		if v.Kind() == reflect.Uint16 {
+			return reflect.Value{}, marshaler.ErrUnsupported
+		}
+		return reflect.Value{}, nil
+	}
+	if _, err := marshaler.NewEncoder("yaml", fail).Encode(example.Config{Servers: []example.Server{{}}}); !errors.Is(err, marshaler.ErrUnsupported) {
+		t.Error("Expected ErrUnsupported, got", err)
+	}
+}
+
+func Test_Example_008(t *testing.T) {
+	// Generated methods promoted from an embedded structure are not used
+	type outer struct {
+		example.Base
+		Name string `yaml:"name"`
+	}
+	var dest outer
+	if err := marshaler.NewDecoder("yaml").SetStrict(true).Decode(map[string]interface{}{"id": 1, "name": "x"}, &dest); err != nil {
+		t.Fatal(err)
+	} else if dest.ID != 1 || dest.Name != "x" {
+		t.Errorf("Unexpected %+v", dest)
+	}
+	if result, err := marshaler.NewEncoder("yaml").Encode(dest); err != nil {
+		t.Fatal(err)
+	} else if result["id"] != 1 || result["name"] != "x" {
+		t.Errorf("Unexpected %v", result)
+	}
+
+	// Nested outer structures, and outer structures passed to DecodeMap
+	var config struct {
+		Servers []outer `yaml:"servers"`
+	}
+	if err := marshaler.NewDecoder("yaml").Decode(map[string]interface{}{"servers": []interface{}{map[string]interface{}{"id": 2, "name": "y"}}}, &config); err != nil {
+		t.Fatal(err)
+	} else if len(config.Servers) != 1 || config.Servers[0].ID != 2 || config.Servers[0].Name != "y" {
+		t.Errorf("Unexpected %+v", config)
+	}
+	dest = outer{}
+	if err := marshaler.NewDecoder("yaml").DecodeMap("", map[string]interface{}{"id": 3, "name": "z"}, &dest); err != nil {
+		t.Fatal(err)
+	} else if dest.ID != 3 || dest.Name != "z" {
+		t.Errorf("Unexpected %+v", dest)
+	}
+}
@@ -0,0 +1,38 @@
+// Package example contains structures with DecodeMap and EncodeMap methods
+// generated by marshalergen
+package example
+
+import (
+	"time"
+)
+
+//go:generate go run .. -tag yaml
+
+///////////////////////////////////////////////////////////////////////////////
+// TYPES
+
+type Base struct {
+	ID      int       `yaml:"id,required"`
+	Created time.Time `yaml:"created,omitempty"`
+}
+
+type Meta struct {
+	Labels map[string]string `yaml:"labels,omitnil"`
+}
+
+type Server struct {
+	Base
+	*Meta
+	Name    string        `yaml:"name"`
+	Port    uint16        `yaml:"port,default=8080"`
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	Enabled bool          `yaml:"enabled"`
+	Tags    []string      `yaml:"tags,omitempty,default=web, api"`
+	Ignored string        `yaml:"-"`
+}
+
+type Config struct {
+	Servers []Server `yaml:"servers"`
+	Primary *Server  `yaml:"primary,omitnil"`
+	Weight  float64  `yaml:"weight"`
+}
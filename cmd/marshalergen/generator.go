@@ -0,0 +1,709 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// TYPES
+
+// Generator generates DecodeMap and EncodeMap methods for structures
+type Generator struct {
+	// The struct tag name
+	Tag string
+
+	// The type names to generate methods for, or all types with the tag
+	// if empty
+	Types []string
+
+	buf       bytes.Buffer
+	pkg       *types.Package
+	imports   map[string]string
+	generated map[string]bool
+}
+
+// field is a field within a structure, which may be promoted from an
+// embedded structure
+type field struct {
+	// The name of the field, from the tag or the field name
+	name string
+
+	// The selector for the field from the structure, for example Base.ID
+	selector string
+
+	// The embedded pointers to allocate before setting the field
+	ptrs []embeddedPtr
+
+	// The path of field indexes from the outer structure
+	index []int
+
+	// The field type and tag options
+	typ  types.Type
+	tags []string
+
+	// The depth of embedding, zero for fields of the outer structure
+	depth int
+}
+
+// embeddedPtr is an embedded pointer to a structure
+type embeddedPtr struct {
+	selector string
+	elem     types.Type
+}
+
+// kind determines the code generated for a type, where values of other
+// types are decoded and encoded with the calling decoder or encoder
+type kind int
+
+///////////////////////////////////////////////////////////////////////////////
+// GLOBALS
+
+const (
+	marshalerPath = "github.com/djthorpe/go-marshaler"
+	generatedBy   = "// Code generated by marshalergen. DO NOT EDIT."
+)
+
+const (
+	kindNone   kind = iota
+	kindValue       // basic types, time.Time and time.Duration
+	kindStruct      // generated structures
+	kindPtr         // pointers to generated structures
+	kindSlice       // slices of other kinds
+	kindMap         // maps with string keys of other kinds
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// PUBLIC METHODS
+
+// Generate returns the formatted source for a package
+func (g *Generator) Generate(pkg *types.Package) ([]byte, error) {
+	g.buf.Reset()
+	g.pkg = pkg
+	g.imports = map[string]string{marshalerPath: "marshaler"}
+	g.generated = make(map[string]bool)
+
+	// Find the structures
+	names, err := g.structs()
+	if err != nil {
+		return nil, err
+	} else if len(names) == 0 {
+		return nil, fmt.Errorf("no structures with tag %q in package %q", g.Tag, pkg.Name())
+	}
+	for _, name := range names {
+		g.generated[name] = true
+	}
+
+	// Generate the methods for each structure
+	for _, name := range names {
+		obj := pkg.Scope().Lookup(name)
+		if err := g.generateType(obj.Name(), obj.Type().Underlying().(*types.Struct)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Prepend the header and imports
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "%s\n\npackage %s\n\nimport (\n", generatedBy, pkg.Name())
+	paths := make([]string, 0, len(g.imports))
+	for path := range g.imports {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if std := isStd(paths[i]); std != isStd(paths[j]) {
+			return std
+		}
+		return paths[i] < paths[j]
+	})
+	for i, path := range paths {
+		if i > 0 && isStd(path) != isStd(paths[i-1]) {
+			fmt.Fprintf(&src, "\n")
+		}
+		if name := g.imports[path]; name == filepath.Base(path) {
+			fmt.Fprintf(&src, "%q\n", path)
+		} else {
+			fmt.Fprintf(&src, "%s %q\n", name, path)
+		}
+	}
+	fmt.Fprintf(&src, ")\n\n")
+	src.Write(g.buf.Bytes())
+
+	// Format the source
+	if result, err := format.Source(src.Bytes()); err != nil {
+		return nil, fmt.Errorf("%w\n%s", err, src.String())
+	} else {
+		return result, nil
+	}
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+// loadPackage parses and type checks the package in a directory, ignoring
+// any files previously generated by marshalergen
+func loadPackage(dir, output string) (*types.Package, error) {
+	ctx, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, name := range ctx.GoFiles {
+		if output != "" && name == filepath.Base(output) {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		if len(file.Comments) > 0 && strings.HasPrefix(file.Comments[0].Text(), strings.TrimPrefix(generatedBy, "// ")) {
+			continue
+		}
+		files = append(files, file)
+	}
+
+	// Type check the files, ignoring errors from methods which are
+	// not yet generated
+	config := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error:    func(error) {},
+	}
+	pkg, _ := config.Check(ctx.ImportPath, fset, files, nil)
+	if pkg == nil {
+		return nil, fmt.Errorf("cannot load package in %q", dir)
+	}
+	return pkg, nil
+}
+
+// structs returns the names of the structures to generate methods for
+func (g *Generator) structs() ([]string, error) {
+	var result []string
+	if len(g.Types) > 0 {
+		for _, name := range g.Types {
+			if _, ok := g.named(name); !ok {
+				return nil, fmt.Errorf("%q is not a structure in package %q", name, g.pkg.Name())
+			}
+			result = append(result, name)
+		}
+		return result, nil
+	}
+	for _, name := range g.pkg.Scope().Names() {
+		if st, ok := g.named(name); ok && g.hasTag(st, map[*types.Struct]bool{}) {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
+
+// named returns the structure for a type name, which should not be generic
+func (g *Generator) named(name string) (*types.Struct, bool) {
+	obj, ok := g.pkg.Scope().Lookup(name).(*types.TypeName)
+	if !ok || obj.IsAlias() {
+		return nil, false
+	}
+	named, ok := obj.Type().(*types.Named)
+	if !ok || named.TypeParams().Len() > 0 {
+		return nil, false
+	}
+	st, ok := named.Underlying().(*types.Struct)
+	return st, ok
+}
+
+// hasTag returns true if any field of a structure, including embedded
+// structures, has the tag
+func (g *Generator) hasTag(st *types.Struct, visited map[*types.Struct]bool) bool {
+	if visited[st] {
+		return false
+	}
+	visited[st] = true
+	for i := 0; i < st.NumFields(); i++ {
+		if _, exists := reflect.StructTag(st.Tag(i)).Lookup(g.Tag); exists {
+			return true
+		}
+		if embedded := embeddedStruct(st.Field(i)); embedded != nil && g.hasTag(embedded, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// fields returns the fields of a structure with the same rules as the
+// marshaler package: embedded structures without a tag name are squashed,
+// and the least embedded field with each name is returned
+func (g *Generator) fields(st *types.Struct) []field {
+	var fields []field
+	g.appendFields(&fields, st, "", nil, nil, map[*types.Struct]bool{})
+
+	// Determine the dominant field for each name
+	dominant := make(map[string]int, len(fields))
+	for i, f := range fields {
+		if j, exists := dominant[f.name]; !exists || f.depth < fields[j].depth {
+			dominant[f.name] = i
+		} else if f.depth == fields[j].depth {
+			dominant[f.name] = -1
+		}
+	}
+
+	// Return dominant fields in order
+	result := make([]field, 0, len(fields))
+	for i, f := range fields {
+		if dominant[f.name] == i {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+func (g *Generator) appendFields(fields *[]field, st *types.Struct, prefix string, ptrs []embeddedPtr, index []int, visited map[*types.Struct]bool) {
+	// Guard against recursive embedding
+	if visited[st] {
+		return
+	}
+	visited[st] = true
+	defer delete(visited, st)
+
+	for i := 0; i < st.NumFields(); i++ {
+		v := st.Field(i)
+		tags := splitTag(reflect.StructTag(st.Tag(i)).Get(g.Tag))
+		if tags[0] == "-" {
+			continue
+		}
+
+		// Set the selector and index path from the outer structure
+		selector := prefix + v.Name()
+		path := append(append([]int{}, index...), i)
+
+		// Squash embedded structures without a tag name. Fields within private
+		// embedded pointers cannot be allocated and are ignored
+		if embedded := embeddedStruct(v); embedded != nil && tags[0] == "" {
+			if ptr, ok := v.Type().(*types.Pointer); ok {
+				if !v.Exported() {
+					continue
+				}
+				g.appendFields(fields, embedded, selector+".", append(append([]embeddedPtr{}, ptrs...), embeddedPtr{selector, ptr.Elem()}), path, visited)
+			} else {
+				g.appendFields(fields, embedded, selector+".", ptrs, path, visited)
+			}
+			continue
+		}
+
+		// Private fields not supported
+		if !v.Exported() {
+			continue
+		}
+
+		// Set the field name
+		name := tags[0]
+		if name == "" {
+			name = v.Name()
+		}
+
+		*fields = append(*fields, field{name, selector, ptrs, path, v.Type(), tags[1:], len(index)})
+	}
+}
+
+// generateType generates the methods for a structure
+func (g *Generator) generateType(name string, st *types.Struct) error {
+	fields := g.fields(st)
+
+	// MarshalerTag
+	g.printf("// MarshalerTag returns the tag name used by DecodeMap and EncodeMap\n")
+	g.printf("func (v %s) MarshalerTag() string {\nreturn %q\n}\n\n", name, g.Tag)
+
+	// MarshalerType
+	g.printf("// MarshalerType returns a nil pointer to %s, so that DecodeMap and EncodeMap\n", name)
+	g.printf("// are not used for structures which embed it\n")
+	g.printf("func (v %s) MarshalerType() interface{} {\nreturn (*%s)(nil)\n}\n\n", name, name)
+
+	// DecodeMap
+	g.printf("// DecodeMap decodes a map into %s, with the same semantics as marshaler.Decoder\n", name)
+	g.printf("func (v *%s) DecodeMap(dec *marshaler.Decoder, src map[string]interface{}) error {\n", name)
+	g.printf("var result marshaler.Errors\n")
+	for _, f := range fields {
+		g.generateDecodeField(f)
+	}
+	g.printf("if len(result) > 0 {\nreturn result\n}\nreturn nil\n}\n\n")
+
+	// EncodeMap
+	g.printf("// EncodeMap encodes %s into a map, with the same semantics as marshaler.Encoder\n", name)
+	g.printf("func (v %s) EncodeMap(enc *marshaler.Encoder) (map[string]interface{}, error) {\n", name)
+	g.printf("result := make(map[string]interface{}, %d)\n", len(fields))
+	for _, f := range fields {
+		g.generateEncodeField(f)
+	}
+	g.printf("return result, nil\n}\n\n")
+
+	// Return success
+	return nil
+}
+
+func (g *Generator) generateDecodeField(f field) {
+	g.printf("if value, exists := src[%q]; exists {\n", f.name)
+	g.generateAlloc(f)
+	g.generateDecode("v."+f.selector, f.typ, []string{strconv.Quote(f.name)}, 0)
+	if hasTag(f.tags, "required") {
+		// Fields of nil embedded pointers cannot be addressed
+		dest := "&v." + f.selector
+		if len(f.ptrs) > 0 {
+			dest = "(*" + g.typeString(f.typ) + ")(nil)"
+		}
+		g.printf("} else if err := dec.RequiredError(%q, %s); err != nil {\n", f.name, dest)
+		g.printf("result = result.Append(err)\n")
+	} else if value, exists := tagValue(f.tags, "default"); exists {
+		if len(f.ptrs) > 0 {
+			g.printf("} else {\n")
+			g.generateAlloc(f)
+		} else {
+			g.printf("} else ")
+		}
+		if isList(f.typ) {
+			g.printf("if err := dec.DecodeValue(%q, %#v, &v.%s); err != nil {\n", f.name, splitDefault(value), f.selector)
+		} else {
+			g.printf("if err := dec.DecodeValue(%q, %q, &v.%s); err != nil {\n", f.name, value, f.selector)
+		}
+		g.printf("result = result.Append(err)\n")
+		if len(f.ptrs) > 0 {
+			g.printf("}\n")
+		}
+	}
+	g.printf("}\n")
+}
+
+// generateDecode decodes the variable value into target, where path is the
+// list of literals and expressions for the path of the value. Values are
+// decoded directly when they have the expected type and the decoder hooks
+// pass them through, or else with the decoder. The hooks are checked for
+// fields, and elements of slices and maps are then decoded directly
+func (g *Generator) generateDecode(target string, t types.Type, path []string, depth int) {
+	value, suffix, cond := "value", strconv.Itoa(depth+1), " && dec.PassThrough()"
+	if depth > 0 {
+		value, cond = value+strconv.Itoa(depth), ""
+	}
+	switch g.kind(t) {
+	case kindValue:
+		g.printf("if x, ok := %s.(%s); ok%s {\n", value, g.typeString(t), cond)
+		g.printf("%s = x\n", target)
+		g.printf("} else ")
+	case kindStruct:
+		g.printf("if m, ok := %s.(map[string]interface{}); ok%s {\n", value, cond)
+		g.printf("if err := dec.DecodeMap(%s, m, &%s); err != nil {\nresult = result.Append(err)\n}\n", pathExpr(path), target)
+		g.printf("} else ")
+	case kindPtr:
+		g.printf("if m, ok := %s.(map[string]interface{}); ok%s {\n", value, cond)
+		g.printf("if %s == nil {\n%s = new(%s)\n}\n", target, target, g.typeString(t.(*types.Pointer).Elem()))
+		g.printf("if err := dec.DecodeMap(%s, m, %s); err != nil {\nresult = result.Append(err)\n}\n", pathExpr(path), target)
+		g.printf("} else ")
+	case kindSlice:
+		g.imports["strconv"] = "strconv"
+		i := "i" + suffix
+		g.printf("if s, ok := %s.([]interface{}); ok%s {\n", value, cond)
+		g.printf("%s = make(%s, len(s))\n", target, g.typeString(t))
+		g.printf("for %s, value%s := range s {\n", i, suffix)
+		g.generateDecode(target+"["+i+"]", t.Underlying().(*types.Slice).Elem(), append(path[:len(path):len(path)], `"["`, "strconv.Itoa("+i+")", `"]"`), depth+1)
+		g.printf("}\n")
+		g.printf("} else ")
+	case kindMap:
+		key, elem, n := "key"+suffix, "elem"+suffix, "n"+suffix
+		g.printf("if m, ok := %s.(map[string]interface{}); ok%s {\n", value, cond)
+		g.printf("%s = make(%s, len(m))\n", target, g.typeString(t))
+		g.printf("for %s, value%s := range m {\n", key, suffix)
+		g.printf("var %s %s\n%s := len(result)\n", elem, g.typeString(t.Underlying().(*types.Map).Elem()), n)
+		g.generateDecode(elem, t.Underlying().(*types.Map).Elem(), append(path[:len(path):len(path)], `"."`, key), depth+1)
+		g.printf("if len(result) == %s {\n%s[%s] = %s\n}\n", n, target, key, elem)
+		g.printf("}\n")
+		g.printf("} else ")
+	}
+	g.printf("if err := dec.DecodeValue(%s, %s, &%s); err != nil {\n", pathExpr(path), value, target)
+	g.printf("result = result.Append(err)\n}\n")
+}
+
+func (g *Generator) generateEncodeField(f field) {
+	// Skip fields promoted through nil embedded pointers
+	for _, ptr := range f.ptrs {
+		g.printf("if v.%s != nil {\n", ptr.selector)
+	}
+
+	// Skip empty or nil values
+	var conditions []string
+	if hasTag(f.tags, "omitempty") {
+		if cond := notEmpty("v."+f.selector, f.typ); cond != "" {
+			conditions = append(conditions, cond)
+		}
+	}
+	if hasTag(f.tags, "omitnil") {
+		if cond := notNil("v."+f.selector, f.typ); cond != "" {
+			conditions = append(conditions, cond)
+		}
+	}
+	if len(conditions) > 0 {
+		g.printf("if %s {\n", strings.Join(conditions, " && "))
+	}
+
+	// Set the value directly when there are no encoder hooks, or else
+	// with the encoder
+	target := fmt.Sprintf("result[%q]", f.name)
+	if g.kind(f.typ) != kindNone {
+		g.printf("if enc.PassThrough() {\n")
+		g.generateEncode(target, "v."+f.selector, f.typ, len(conditions) > 0, 0)
+		g.printf("} else ")
+	}
+	g.printf("if value, err := enc.EncodeValue(v.%s); err != nil {\n", f.selector)
+	g.printf("return nil, err\n")
+	g.printf("} else {\n%s = value\n}\n", target)
+
+	if len(conditions) > 0 {
+		g.printf("}\n")
+	}
+	for range f.ptrs {
+		g.printf("}\n")
+	}
+}
+
+// generateEncode encodes expr into target without encoder hooks, where nested
+// structures are encoded into maps, and slices and maps are encoded element
+// by element when they contain structures. Nil values are encoded as nil,
+// unless expr is known not to be nil
+func (g *Generator) generateEncode(target, expr string, t types.Type, notNil bool, depth int) {
+	suffix := ""
+	if depth > 0 {
+		suffix = strconv.Itoa(depth)
+	}
+	switch g.kind(t) {
+	case kindValue:
+		g.printf("%s = %s\n", target, expr)
+	case kindStruct:
+		g.printf("if m, err := %s.EncodeMap(enc); err != nil {\nreturn nil, err\n} else {\n%s = m\n}\n", expr, target)
+	case kindPtr:
+		if !notNil {
+			g.printf("if %s == nil {\n%s = nil\n} else ", expr, target)
+		}
+		g.printf("if m, err := %s.EncodeMap(enc); err != nil {\nreturn nil, err\n} else {\n%s = m\n}\n", expr, target)
+	case kindSlice, kindMap:
+		if !notNil {
+			g.printf("if %s == nil {\n%s = nil\n} else {\n", expr, target)
+		}
+		if elem := t.Underlying().(interface{ Elem() types.Type }).Elem(); !g.needsEncoding(elem) {
+			g.printf("%s = %s\n", target, expr)
+		} else if x := "x" + suffix; g.kind(t) == kindSlice {
+			i := "i" + suffix
+			g.printf("%s := make([]interface{}, len(%s))\n", x, expr)
+			g.printf("for %s := range %s {\n", i, expr)
+			g.generateEncode(x+"["+i+"]", expr+"["+i+"]", elem, false, depth+1)
+			g.printf("}\n%s = %s\n", target, x)
+		} else {
+			key, value := "key"+suffix, "value"+suffix
+			g.printf("%s := make(map[string]interface{}, len(%s))\n", x, expr)
+			g.printf("for %s, %s := range %s {\n", key, value, expr)
+			g.generateEncode(x+"["+key+"]", value, elem, false, depth+1)
+			g.printf("}\n%s = %s\n", target, x)
+		}
+		if !notNil {
+			g.printf("}\n")
+		}
+	}
+}
+
+// generateAlloc allocates the embedded pointers for a field
+func (g *Generator) generateAlloc(f field) {
+	for _, ptr := range f.ptrs {
+		g.printf("if v.%s == nil {\n", ptr.selector)
+		g.printf("v.%s = new(%s)\n}\n", ptr.selector, g.typeString(ptr.elem))
+	}
+}
+
+// kind returns the kind of code generated for a type. Slices, maps and
+// pointers should be unnamed, so they do not have their own methods
+func (g *Generator) kind(t types.Type) kind {
+	switch t := types.Unalias(t).(type) {
+	case *types.Basic:
+		if t.Info()&(types.IsBoolean|types.IsNumeric|types.IsString) != 0 && t.Info()&(types.IsUntyped|types.IsComplex) == 0 {
+			return kindValue
+		}
+	case *types.Named:
+		if obj := t.Obj(); obj.Pkg() == g.pkg && g.generated[obj.Name()] {
+			return kindStruct
+		} else if obj.Pkg() != nil && obj.Pkg().Path() == "time" && (obj.Name() == "Time" || obj.Name() == "Duration") {
+			return kindValue
+		}
+	case *types.Pointer:
+		if g.kind(t.Elem()) == kindStruct {
+			return kindPtr
+		}
+	case *types.Slice:
+		if g.kind(t.Elem()) != kindNone {
+			return kindSlice
+		}
+	case *types.Map:
+		if key, ok := types.Unalias(t.Key()).(*types.Basic); ok && key.Kind() == types.String && g.kind(t.Elem()) != kindNone {
+			return kindMap
+		}
+	}
+	return kindNone
+}
+
+// needsEncoding returns true if the encoder converts values of a type,
+// rather than returning them as they are
+func (g *Generator) needsEncoding(t types.Type) bool {
+	switch g.kind(t) {
+	case kindStruct, kindPtr:
+		return true
+	case kindSlice, kindMap:
+		return g.needsEncoding(t.Underlying().(interface{ Elem() types.Type }).Elem())
+	default:
+		return false
+	}
+}
+
+// typeString returns the type as source, adding any imports
+func (g *Generator) typeString(t types.Type) string {
+	return types.TypeString(t, func(pkg *types.Package) string {
+		if pkg == g.pkg {
+			return ""
+		}
+		g.imports[pkg.Path()] = pkg.Name()
+		return pkg.Name()
+	})
+}
+
+func (g *Generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// embeddedStruct returns the structure for an embedded field of structure
+// or pointer to structure type, or nil otherwise
+func embeddedStruct(v *types.Var) *types.Struct {
+	if !v.Embedded() {
+		return nil
+	}
+	t := v.Type()
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	st, _ := t.Underlying().(*types.Struct)
+	return st
+}
+
+// notEmpty returns the condition for a value which is not empty, as for
+// encoding/json, or an empty string if the value is never empty
+func notEmpty(expr string, t types.Type) string {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case u.Info()&types.IsBoolean != 0:
+			return expr
+		case u.Info()&types.IsString != 0:
+			return expr + ` != ""`
+		case u.Info()&types.IsNumeric != 0:
+			return expr + " != 0"
+		}
+	case *types.Slice, *types.Map, *types.Array, *types.Chan:
+		return "len(" + expr + ") != 0"
+	case *types.Pointer, *types.Interface:
+		return expr + " != nil"
+	}
+	return ""
+}
+
+// notNil returns the condition for a value which is not nil, or an empty
+// string if the value cannot be nil
+func notNil(expr string, t types.Type) string {
+	switch t.Underlying().(type) {
+	case *types.Slice, *types.Map, *types.Pointer, *types.Interface:
+		return expr + " != nil"
+	}
+	return ""
+}
+
+// pathExpr returns the expression which joins a list of string literals and
+// expressions, where adjacent literals are joined into one literal
+func pathExpr(path []string) string {
+	var result []string
+	for _, elem := range path {
+		if i := len(result) - 1; i >= 0 && strings.HasPrefix(elem, `"`) && strings.HasPrefix(result[i], `"`) {
+			prefix, _ := strconv.Unquote(result[i])
+			suffix, _ := strconv.Unquote(elem)
+			result[i] = strconv.Quote(prefix + suffix)
+		} else {
+			result = append(result, elem)
+		}
+	}
+	return strings.Join(result, " + ")
+}
+
+// isStd returns true for standard library import paths
+func isStd(path string) bool {
+	return !strings.Contains(strings.SplitN(path, "/", 2)[0], ".")
+}
+
+// isList returns true for slices (or pointers to slices) which are decoded
+// from a list of strings rather than from a single string
+func isList(t types.Type) bool {
+	for {
+		ptr, ok := types.Unalias(t).(*types.Pointer)
+		if !ok {
+			break
+		}
+		t = ptr.Elem()
+	}
+	if _, ok := t.Underlying().(*types.Slice); !ok {
+		return false
+	}
+	return types.NewMethodSet(types.NewPointer(t)).Lookup(nil, "UnmarshalText") == nil
+}
+
+// splitTag returns the name and options of a tag, where the default= option
+// is the rest of the tag, so that a default value can contain commas
+func splitTag(tag string) []string {
+	tags := strings.Split(tag, ",")
+	for i := 1; i < len(tags); i++ {
+		if strings.HasPrefix(tags[i], "default=") {
+			return append(tags[:i], strings.Join(tags[i:], ","))
+		}
+	}
+	return tags
+}
+
+// splitDefault returns the trimmed elements of a comma-separated default
+// value for a slice, in the same way as the decoder
+func splitDefault(value string) []string {
+	if strings.TrimSpace(value) == "" {
+		return []string{}
+	}
+	result := strings.Split(value, ",")
+	for i := range result {
+		result[i] = strings.TrimSpace(result[i])
+	}
+	return result
+}
+
+// hasTag returns true if the tag options include an option
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// tagValue returns the value of a tag option in the form key=value
+func tagValue(tags []string, key string) (string, bool) {
+	for _, t := range tags {
+		if strings.HasPrefix(t, key+"=") {
+			return t[len(key)+1:], true
+		}
+	}
+	return "", false
+}
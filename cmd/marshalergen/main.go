@@ -0,0 +1,93 @@
+// marshalergen generates DecodeMap and EncodeMap methods for structures with
+// a given tag name, which are used by marshaler.Decoder and marshaler.Encoder
+// instead of reflection. For example,
+//
+//	//go:generate marshalergen -tag yaml
+//
+// Values which are not assigned directly are converted with the calling
+// decoder or encoder, so have the same semantics, hooks and limits as
+// Decode and Encode.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// GLOBALS
+
+var (
+	flagTag    = flag.String("tag", "", "Struct tag name (required)")
+	flagTypes  = flag.String("type", "", "Comma-separated list of type names (default all types with the tag)")
+	flagOutput = flag.String("output", "", "Output file name (default <package>_marshaler.go)")
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// MAIN
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s -tag <name> [flags] [directory]\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if err := run(flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if *flagTag == "" {
+		return fmt.Errorf("missing -tag flag")
+	}
+
+	// Set the package directory
+	dir := "."
+	if len(args) > 1 {
+		return fmt.Errorf("too many arguments")
+	} else if len(args) == 1 {
+		dir = args[0]
+	}
+
+	// Load the package, ignoring any previously generated file
+	pkg, err := loadPackage(dir, *flagOutput)
+	if err != nil {
+		return err
+	}
+
+	// Generate the source
+	gen := &Generator{
+		Tag:   *flagTag,
+		Types: splitList(*flagTypes),
+	}
+	source, err := gen.Generate(pkg)
+	if err != nil {
+		return err
+	}
+
+	// Write the output file
+	output := *flagOutput
+	if output == "" {
+		output = strings.ToLower(pkg.Name()) + "_marshaler.go"
+	}
+	if !filepath.IsAbs(output) {
+		output = filepath.Join(dir, output)
+	}
+	return os.WriteFile(output, source, 0644)
+}
+
+// splitList returns the non-empty elements of a comma-separated list
+func splitList(value string) []string {
+	var result []string
+	for _, elem := range strings.Split(value, ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			result = append(result, elem)
+		}
+	}
+	return result
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Generator_001(t *testing.T) {
+	// The generated example should be up to date
+	dir := filepath.Join(".", "example")
+	pkg, err := loadPackage(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := (&Generator{Tag: "yaml"}).Generate(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden, err := os.ReadFile(filepath.Join(dir, "example_marshaler.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(source, golden) {
+		t.Error("example_marshaler.go is out of date, run go generate ./...")
+	}
+	if bytes.Contains(source, []byte(`"reflect"`)) {
+		t.Error("Unexpected reflect import")
+	}
+}
+
+func Test_Generator_002(t *testing.T) {
+	// Select types by name
+	pkg, err := loadPackage(filepath.Join(".", "example"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := (&Generator{Tag: "yaml", Types: []string{"Meta"}}).Generate(pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(source, []byte("func (v *Meta) DecodeMap(")) {
+		t.Error("Expected DecodeMap for Meta")
+	}
+	if bytes.Contains(source, []byte("func (v *Server) DecodeMap(")) {
+		t.Error("Unexpected DecodeMap for Server")
+	}
+	if bytes.Contains(source, []byte(`"reflect"`)) {
+		t.Error("Unexpected reflect import")
+	}
+}
+
+func Test_Generator_003(t *testing.T) {
+	// Unknown types and tags return errors
+	pkg, err := loadPackage(filepath.Join(".", "example"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&Generator{Tag: "yaml", Types: []string{"Missing"}}).Generate(pkg); err == nil {
+		t.Error("Expected error for missing type")
+	}
+	if _, err := (&Generator{Tag: "json"}).Generate(pkg); err == nil {
+		t.Error("Expected error for missing tag")
+	}
+}
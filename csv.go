@@ -0,0 +1,330 @@
+package marshaler
+
+import (
+	"encoding/csv"
+	"io"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// PUBLIC METHODS
+
+// DecodeCSV reads a header row and then every record from r, and appends
+// them to dest, which should be a pointer to a slice of structures, pointers
+// to structures or maps. Columns are matched to tag names, where dotted names
+// such as db.host are matched to fields of nested structures, cells are
+// strings which are converted by the conversion functions, cells for slices
+// are split on commas, and empty cells are treated as missing values. Errors
+// include the index of the record and the column, for example [0].port for
+// the first record after the header, and the line number in the file, and
+// records with errors are appended as far as they could be decoded
+func (this *Decoder) DecodeCSV(r *csv.Reader, dest interface{}) error {
+	d := reflect.ValueOf(dest)
+	if d.Kind() != reflect.Ptr || d.IsNil() || d.Elem().Kind() != reflect.Slice {
+		return ErrBadParameter.With("DecodeCSV: destination should be ptr to slice")
+	} else {
+		d = d.Elem()
+	}
+	return this.decodeCSV(r, d.Type().Elem(), func(elem reflect.Value, err error) error {
+		d.Set(reflect.Append(d, elem))
+		return nil
+	})
+}
+
+// DecodeCSVFunc reads a header row and then each record from r in turn into
+// dest, which should be a pointer to a structure, and calls fn after each
+// record is decoded, so that large files do not need to be held in memory.
+// The destination is reset to the zero value before each record. Records
+// with errors are not passed to fn, and their errors are returned when all
+// records have been read. Reading stops if fn returns an error
+func (this *Decoder) DecodeCSVFunc(r *csv.Reader, dest interface{}, fn func() error) error {
+	d, err := structDest(dest, "DecodeCSVFunc")
+	if err != nil {
+		return err
+	} else if fn == nil {
+		return ErrBadParameter.With("DecodeCSVFunc: nil function")
+	}
+	return this.decodeCSV(r, d.Type(), func(elem reflect.Value, err error) error {
+		if err != nil {
+			return nil
+		}
+		d.Set(elem)
+		return fn()
+	})
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+// decodeCSV decodes each record into a new value of type t, and calls fn
+// with the value and any decoding errors for the record
+func (this *Decoder) decodeCSV(r *csv.Reader, t reflect.Type, fn func(reflect.Value, error) error) error {
+	if r == nil {
+		return ErrBadParameter.With("DecodeCSV: nil reader")
+	}
+
+	// Read the header row
+	columns, err := r.Read()
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	columns = append([]string{}, columns...)
+	header, _ := r.FieldPos(0)
+
+	// Match each column to a field, and check for columns which do not match
+	// any field in strict mode
+	u := &unmarshaler{name: this.name, fn: this.unmarshalscalar, max: this.maxErrors}
+	var result Errors
+	paths := make([][]string, len(columns))
+	lists := make([]bool, len(columns))
+	for j, column := range columns {
+		var exists bool
+		paths[j], lists[j], exists = this.csvColumn(t, column)
+		if !exists {
+			paths[j] = []string{column}
+			if this.strict && !u.done() && isNestedStruct(elemType(t)) {
+				result = u.appendError(result, lineError(newFieldError(column, nil, nil, ErrUnknownField), header))
+			}
+		}
+	}
+
+	// Decode each record
+	for i := 0; !u.done(); i++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for j, cell := range record {
+			if j >= len(columns) || cell == "" {
+				continue
+			} else if lists[j] {
+				setPath(row, paths[j], splitList(cell))
+			} else {
+				setPath(row, paths[j], cell)
+			}
+		}
+		line, _ := r.FieldPos(0)
+		elem := reflect.New(t).Elem()
+		err = u.unmarshalValue(reflect.ValueOf(row), elem, indexPath("", i))
+		if err != nil {
+			err = lineError(err, line)
+			result = u.appendError(result, err)
+		}
+		if err := fn(elem, err); err != nil {
+			return err
+		}
+	}
+
+	// Return any errors
+	return result.errorOrNil()
+}
+
+// csvColumn returns the path of tag names for a column of a structure, where
+// dotted names are matched to fields of nested structures, and whether the
+// cells are comma-separated lists. It returns false if the column does not
+// match any field
+func (this *Decoder) csvColumn(t reflect.Type, column string) ([]string, bool, bool) {
+	t = elemType(t)
+	if !isNestedStruct(t) {
+		return nil, false, false
+	}
+	for _, field := range structFields(t, this.name).fields {
+		ft := elemType(field.Type)
+		if field.name == column {
+			list := ft.Kind() == reflect.Slice && !reflect.PtrTo(ft).Implements(textUnmarshalerType)
+			return []string{field.name}, list, true
+		} else if !strings.HasPrefix(column, field.name+".") {
+			continue
+		} else if path, list, exists := this.csvColumn(ft, column[len(field.name)+1:]); exists {
+			return append([]string{field.name}, path...), list, true
+		}
+	}
+	return nil, false, false
+}
+
+// lineError returns an error with the line number set on any FieldError
+func lineError(err error, line int) error {
+	switch e := err.(type) {
+	case Errors:
+		result := make(Errors, len(e))
+		for i, err := range e {
+			result[i] = lineError(err, line)
+		}
+		return result
+	case *FieldError:
+		copy := *e
+		copy.Line = line
+		return &copy
+	default:
+		return err
+	}
+}
+
+// EncodeCSV writes a header row and then a record for each element of src,
+// which should be a slice of structures or pointers to structures. Columns
+// are the tag names in field order, or the columns provided in that order,
+// where fields of nested structures have dotted names such as db.host.
+// Values are converted by the hooks and formatted in the same way as
+// EncodeQuery, where slices are separated by commas. Only the columns
+// written are formatted, and slice elements which contain commas are not
+// escaped, so they are split into separate elements by DecodeCSV
+func (this *Encoder) EncodeCSV(w *csv.Writer, src interface{}, columns ...string) error {
+	if w == nil {
+		return ErrBadParameter.With("EncodeCSV: nil writer")
+	}
+	rv := reflect.ValueOf(src)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return ErrBadParameter.With("EncodeCSV: expected slice but got ", rv.Kind())
+	}
+	t := rv.Type().Elem()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return ErrBadParameter.With("EncodeCSV: expected slice of struct but got ", t)
+	}
+
+	// Set the columns from the fields, or check the columns provided
+	var names []string
+	this.csvFields(reflect.Zero(t), "", func(name string, _ reflect.Value) error {
+		names = append(names, name)
+		return nil
+	}, map[reflect.Type]bool{})
+	if len(columns) == 0 {
+		columns = names
+	} else {
+		known := make(map[string]bool, len(names))
+		for _, name := range names {
+			known[name] = true
+		}
+		for _, column := range columns {
+			if !known[column] {
+				return ErrBadParameter.With("EncodeCSV: unknown column ", column)
+			}
+		}
+	}
+	selected := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		selected[column] = true
+	}
+
+	// Write the header
+	if err := w.Write(columns); err != nil {
+		return err
+	}
+
+	// Write the records
+	for i := 0; i < rv.Len(); i++ {
+		values := make(map[string]string, len(columns))
+		v := rv.Index(i)
+		for v.Kind() == reflect.Ptr && !v.IsNil() {
+			v = v.Elem()
+		}
+		if v.Kind() == reflect.Struct {
+			if err := this.csvFields(v, "", func(name string, v reflect.Value) error {
+				if !selected[name] {
+					return nil
+				}
+				value, err := this.csvValue(v)
+				if err != nil {
+					return &FieldError{Path: indexPath("", i) + "." + name, Key: name, Err: err}
+				}
+				values[name] = value
+				return nil
+			}, map[reflect.Type]bool{}); err != nil {
+				return err
+			}
+		}
+		record := make([]string, len(columns))
+		for j, column := range columns {
+			record[j] = values[column]
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+
+	// Flush and return any errors
+	w.Flush()
+	return w.Error()
+}
+
+// csvFields calls fn with the dotted name and value of each field of a
+// structure, where fields of nested structures are flattened. The value is
+// invalid for fields within a nil pointer
+func (this *Encoder) csvFields(rv reflect.Value, path string, fn func(string, reflect.Value) error, visited map[reflect.Type]bool) error {
+	// Guard against recursive structures
+	if visited[rv.Type()] {
+		return nil
+	}
+	visited[rv.Type()] = true
+	defer delete(visited, rv.Type())
+
+	for _, field := range this.reflect(rv, true) {
+		name := joinPath(path, field.Name)
+		v, t := field.Value, field.Type
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+			if v.IsValid() && !v.IsNil() {
+				v = v.Elem()
+			} else {
+				v = reflect.Value{}
+			}
+		}
+		if t.Kind() == reflect.Struct && t != timeType && !hasMarshaler(t) {
+			if !v.IsValid() {
+				// Fields within a nil pointer have no values
+				if err := this.csvFields(reflect.Zero(t), name, func(name string, _ reflect.Value) error {
+					return fn(name, reflect.Value{})
+				}, visited); err != nil {
+					return err
+				}
+			} else if err := this.csvFields(v, name, fn, visited); err != nil {
+				return err
+			}
+		} else if err := fn(name, field.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setPath sets a value in nested maps, creating the maps as necessary
+func setPath(m map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		child, ok := m[key].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			m[key] = child
+		}
+		m = child
+	}
+	m[path[len(path)-1]] = value
+}
+
+// elemType returns the type pointed to by t, following pointers
+func elemType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// csvValue returns a value formatted for a cell
+func (this *Encoder) csvValue(v reflect.Value) (string, error) {
+	if !v.IsValid() {
+		return "", nil
+	}
+	values := make(url.Values, 1)
+	if err := this.encodeQueryValue(values, "", v, true); err != nil {
+		return "", err
+	}
+	return strings.Join(values[""], ","), nil
+}
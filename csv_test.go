@@ -0,0 +1,276 @@
+package marshaler_test
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djthorpe/go-marshaler"
+)
+
+type csvRow struct {
+	Name    string        `csv:"name,required"`
+	Port    uint16        `csv:"port,default=80"`
+	Weight  *float64      `csv:"weight"`
+	Created time.Time     `csv:"created"`
+	Timeout time.Duration `csv:"timeout"`
+}
+
+const csvData = `name,port,weight,created,timeout,other
+alpha,8080,0.5,2020-01-02T03:04:05Z,5s,x
+beta,,,,,
+`
+
+func Test_CSV_001(t *testing.T) {
+	dest := []csvRow{{Name: "existing"}}
+	if err := marshaler.NewDecoder("csv", marshaler.ConvertStringToNumber, marshaler.ConvertTime, marshaler.ConvertDuration).DecodeCSV(csv.NewReader(strings.NewReader(csvData)), &dest); err != nil {
+		t.Fatal(err)
+	}
+	weight := 0.5
+	expected := []csvRow{
+		{Name: "existing"},
+		{"alpha", 8080, &weight, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), 5 * time.Second},
+		{Name: "beta", Port: 80},
+	}
+	if !reflect.DeepEqual(dest, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, dest)
+	}
+
+	// Pointers to structures and maps
+	var maps []map[string]string
+	if err := marshaler.NewDecoder("csv", marshaler.ConvertStringToNumber, marshaler.ConvertTime, marshaler.ConvertDuration).DecodeCSV(csv.NewReader(strings.NewReader(csvData)), &maps); err != nil {
+		t.Fatal(err)
+	} else if len(maps) != 2 || maps[0]["other"] != "x" || len(maps[1]) != 1 {
+		t.Errorf("Unexpected %v", maps)
+	}
+	var ptrs []*csvRow
+	if err := marshaler.NewDecoder("csv", marshaler.ConvertStringToNumber, marshaler.ConvertTime, marshaler.ConvertDuration).DecodeCSV(csv.NewReader(strings.NewReader(csvData)), &ptrs); err != nil {
+		t.Fatal(err)
+	} else if len(ptrs) != 2 || ptrs[1].Name != "beta" {
+		t.Errorf("Unexpected %v", ptrs)
+	}
+
+	// Dotted columns for nested structures, and lists
+	var servers []csvServer
+	data := "name,tags,address.host,address.port,backup.port\nalpha,\"a, b\",localhost,80,81\nbeta,,,,\n"
+	if err := marshaler.NewDecoder("csv", marshaler.ConvertStringToNumber).SetStrict(true).DecodeCSV(csv.NewReader(strings.NewReader(data)), &servers); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(servers, []csvServer{
+		{Name: "alpha", Tags: []string{"a", "b"}, Address: csvAddress{"localhost", 80}, Backup: &csvAddress{Port: 81}},
+		{Name: "beta"},
+	}) {
+		t.Errorf("Unexpected %+v", servers)
+	}
+}
+
+func Test_CSV_002(t *testing.T) {
+	// Errors include the record index and column, and the line number
+	data := "name,port\nalpha,x\n,80\ngamma,-1\n"
+	var dest []csvRow
+	err := marshaler.NewDecoder("csv", marshaler.ConvertStringToNumber, marshaler.ConvertTime, marshaler.ConvertDuration).SetStrict(true).DecodeCSV(csv.NewReader(strings.NewReader(data)), &dest)
+	var errs marshaler.Errors
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Fatalf("Unexpected %v", err)
+	}
+	paths := make([]string, 0, len(errs))
+	lines := make([]int, 0, len(errs))
+	for _, err := range errs {
+		var fieldErr *marshaler.FieldError
+		if errors.As(err, &fieldErr) {
+			paths = append(paths, fieldErr.Path)
+			lines = append(lines, fieldErr.Line)
+		}
+	}
+	if !reflect.DeepEqual(paths, []string{"[0].port", "[1].name", "[2].port"}) {
+		t.Errorf("Unexpected %v", paths)
+	}
+	if !reflect.DeepEqual(lines, []int{2, 3, 4}) {
+		t.Errorf("Unexpected %v", lines)
+	}
+	if err := errs[0].Error(); !strings.HasPrefix(err, "line 2: [0].port: ") {
+		t.Errorf("Unexpected %q", err)
+	}
+	if len(dest) != 3 || dest[0].Name != "alpha" {
+		t.Errorf("Unexpected %v", dest)
+	}
+
+	// Unknown columns in strict mode are on the header line
+	var fieldErr *marshaler.FieldError
+	if err := marshaler.NewDecoder("csv", marshaler.ConvertStringToNumber, marshaler.ConvertTime, marshaler.ConvertDuration).SetStrict(true).DecodeCSV(csv.NewReader(strings.NewReader(csvData)), &dest); !errors.Is(err, marshaler.ErrUnknownField) {
+		t.Errorf("Unexpected %v", err)
+	} else if !errors.As(err, &fieldErr) || fieldErr.Path != "other" || fieldErr.Line != 1 {
+		t.Errorf("Unexpected %v", err)
+	}
+
+	// Line numbers count quoted newlines and skipped lines
+	data = "name,port\n\"a\nb\",1\n\n,2\n"
+	if err := marshaler.NewDecoder("csv", marshaler.ConvertStringToNumber).DecodeCSV(csv.NewReader(strings.NewReader(data)), &dest); !errors.As(err, &errs) || len(errs) != 1 {
+		t.Errorf("Unexpected %v", err)
+	} else if !errors.As(errs[0], &fieldErr) || fieldErr.Path != "[1].name" || fieldErr.Line != 5 {
+		t.Errorf("Unexpected %v", errs[0])
+	}
+
+	if err := marshaler.NewDecoder("csv").SetStrict(true).DecodeCSV(csv.NewReader(strings.NewReader("name,address.other\nalpha,x\n")), &[]csvServer{}); !errors.Is(err, marshaler.ErrUnknownField) {
+		t.Errorf("Unexpected %v", err)
+	}
+
+	// Malformed records are returned as they are
+	var parseErr *csv.ParseError
+	if err := marshaler.NewDecoder("csv", marshaler.ConvertStringToNumber, marshaler.ConvertTime, marshaler.ConvertDuration).DecodeCSV(csv.NewReader(strings.NewReader("name,port\na\n")), &dest); !errors.As(err, &parseErr) {
+		t.Errorf("Unexpected %v", err)
+	}
+}
+
+func Test_CSV_003(t *testing.T) {
+	// Stream records to a function
+	data := "name,port\nalpha,1\n,2\ngamma,3\ndelta,4\n"
+	var dest csvRow
+	var names []string
+	err := marshaler.NewDecoder("csv", marshaler.ConvertStringToNumber, marshaler.ConvertTime, marshaler.ConvertDuration).DecodeCSVFunc(csv.NewReader(strings.NewReader(data)), &dest, func() error {
+		names = append(names, dest.Name)
+		if dest.Port == 3 {
+			return io.ErrUnexpectedEOF
+		}
+		return nil
+	})
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Unexpected %v", err)
+	}
+	if !reflect.DeepEqual(names, []string{"alpha", "gamma"}) {
+		t.Errorf("Unexpected %v", names)
+	}
+
+	// Errors are returned after every record is read
+	names = nil
+	err = marshaler.NewDecoder("csv", marshaler.ConvertStringToNumber, marshaler.ConvertTime, marshaler.ConvertDuration).DecodeCSVFunc(csv.NewReader(strings.NewReader(data)), &dest, func() error {
+		names = append(names, dest.Name)
+		return nil
+	})
+	if !errors.Is(err, marshaler.ErrRequired) || len(names) != 3 {
+		t.Errorf("Unexpected %v %v", err, names)
+	}
+	if err := marshaler.NewDecoder("csv", marshaler.ConvertStringToNumber, marshaler.ConvertTime, marshaler.ConvertDuration).DecodeCSVFunc(csv.NewReader(strings.NewReader(data)), dest, nil); !errors.Is(err, marshaler.ErrBadParameter) {
+		t.Errorf("Unexpected %v", err)
+	}
+}
+
+type csvAddress struct {
+	Host string `csv:"host"`
+	Port int    `csv:"port"`
+}
+
+type csvServer struct {
+	Name    string        `csv:"name"`
+	Tags    []string      `csv:"tags"`
+	Created time.Time     `csv:"created"`
+	Timeout time.Duration `csv:"timeout"`
+	Address csvAddress    `csv:"address"`
+	Backup  *csvAddress   `csv:"backup"`
+	Weight  *float64      `csv:"weight"`
+	Ignored string        `csv:"-"`
+}
+
+func Test_CSV_004(t *testing.T) {
+	weight := 0.5
+	src := []*csvServer{
+		{"alpha", []string{"a", "b"}, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), 5 * time.Second, csvAddress{"localhost", 80}, &csvAddress{"backup", 81}, &weight, "x"},
+		{Name: "beta, gamma"},
+	}
+	var out strings.Builder
+	if err := marshaler.NewEncoder("csv").EncodeCSV(csv.NewWriter(&out), src); err != nil {
+		t.Fatal(err)
+	}
+	expected := `name,tags,created,timeout,address.host,address.port,backup.host,backup.port,weight
+alpha,"a,b",2020-01-02T03:04:05Z,5s,localhost,80,backup,81,0.5
+"beta, gamma",,0001-01-01T00:00:00Z,0s,,0,,,
+`
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+
+	// Columns in a different order, with hooks
+	out.Reset()
+	if err := marshaler.NewEncoder("csv", marshaler.MarshalTime).EncodeCSV(csv.NewWriter(&out), src, "address.port", "created", "name"); err != nil {
+		t.Fatal(err)
+	}
+	expected = "address.port,created,name\n80,2020-01-02T03:04:05Z,alpha\n0,,\"beta, gamma\"\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func Test_CSV_005(t *testing.T) {
+	// Round trip with DecodeCSV
+	src := []csvRow{{Name: "alpha", Port: 80, Timeout: time.Second}, {Name: "beta", Port: 81}}
+	var out strings.Builder
+	if err := marshaler.NewEncoder("csv").EncodeCSV(csv.NewWriter(&out), src, "name", "port", "timeout"); err != nil {
+		t.Fatal(err)
+	}
+	var dest []csvRow
+	if err := marshaler.NewDecoder("csv", marshaler.ConvertStringToNumber, marshaler.ConvertTime, marshaler.ConvertDuration).DecodeCSV(csv.NewReader(strings.NewReader(out.String())), &dest); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(src, dest) {
+		t.Errorf("Expected %v, got %v", src, dest)
+	}
+
+	// Round trip nested structures and slices in strict mode
+	weight := 0.5
+	servers := []*csvServer{
+		{"alpha", []string{"a", "b"}, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), 5 * time.Second, csvAddress{"localhost", 80}, &csvAddress{"backup", 81}, &weight, ""},
+		{Name: "beta, gamma", Tags: []string{"c"}},
+	}
+	out.Reset()
+	if err := marshaler.NewEncoder("csv").EncodeCSV(csv.NewWriter(&out), servers); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []*csvServer
+	if err := marshaler.NewDecoder("csv", marshaler.ConvertStringToNumber, marshaler.ConvertTime, marshaler.ConvertDuration).SetStrict(true).DecodeCSV(csv.NewReader(strings.NewReader(out.String())), &decoded); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(servers, decoded) {
+		t.Errorf("Expected %+v, got %+v", servers, decoded)
+	}
+
+	// Slice elements which contain commas are split
+	out.Reset()
+	if err := marshaler.NewEncoder("csv").EncodeCSV(csv.NewWriter(&out), []csvServer{{Tags: []string{"a,b"}}}, "tags"); err != nil {
+		t.Fatal(err)
+	}
+	decoded = nil
+	if err := marshaler.NewDecoder("csv").DecodeCSV(csv.NewReader(strings.NewReader(out.String())), &decoded); err != nil {
+		t.Fatal(err)
+	} else if len(decoded) != 1 || !reflect.DeepEqual(decoded[0].Tags, []string{"a", "b"}) {
+		t.Errorf("Unexpected %+v", decoded)
+	}
+
+	// Errors
+	enc := marshaler.NewEncoder("csv")
+	if err := enc.EncodeCSV(csv.NewWriter(&out), src, "other"); !errors.Is(err, marshaler.ErrBadParameter) {
+		t.Errorf("Unexpected %v", err)
+	}
+	if err := enc.EncodeCSV(csv.NewWriter(&out), src[0]); !errors.Is(err, marshaler.ErrBadParameter) {
+		t.Errorf("Unexpected %v", err)
+	}
+	type unsupported struct {
+		Map map[string]int `csv:"map"`
+	}
+	var fieldErr *marshaler.FieldError
+	if err := enc.EncodeCSV(csv.NewWriter(&out), []unsupported{{map[string]int{"a": 1}}}); !errors.As(err, &fieldErr) || fieldErr.Path != "[0].map" || !errors.Is(err, marshaler.ErrUnsupported) {
+		t.Errorf("Unexpected %v", err)
+	}
+
+	// Columns which are not written are not formatted
+	type labels struct {
+		Name   string            `csv:"name"`
+		Labels map[string]string `csv:"labels"`
+	}
+	out.Reset()
+	if err := enc.EncodeCSV(csv.NewWriter(&out), []labels{{"alpha", map[string]string{"a": "b"}}}, "name"); err != nil {
+		t.Error(err)
+	} else if out.String() != "name\nalpha\n" {
+		t.Errorf("Unexpected %q", out.String())
+	}
+}
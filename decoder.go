@@ -1,10 +1,12 @@
 package marshaler
 
 import (
-	"fmt"
+	"encoding"
+	"errors"
 	"math"
 	"net/url"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -14,8 +16,34 @@ import (
 // TYPES
 
 type Decoder struct {
-	name  string
-	hooks []UnmarshalScalarFunc
+	name      string
+	hooks     []UnmarshalScalarFunc
+	strict    bool
+	merge     bool
+	maxErrors int
+	direct    bool
+
+	// The decode in progress when the decoder is passed to a MapDecoder
+	state *unmarshaler
+}
+
+// MapDecoder is implemented by types which decode themselves from a map
+// without reflection, for example by methods generated by marshalergen.
+// The calling decoder is passed so that values decoded with its DecodeValue
+// and DecodeMap methods use its hooks and count towards its maximum number
+// of errors. Errors are returned relative to the map. If the type also has
+// a MarshalerTag method, the methods are only used by decoders with the
+// same tag name, and if it has a MarshalerType method which returns a nil
+// pointer to the type, they are not used for structures which embed it
+type MapDecoder interface {
+	DecodeMap(dec *Decoder, src map[string]interface{}) error
+}
+
+// Metadata returns information about a decode operation
+type Metadata struct {
+	// Keys in the source which did not match any destination field,
+	// with their full path
+	Unused []string
 }
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -29,9 +57,28 @@ var (
 	uintType           = reflect.TypeOf(uint(0))
 	int64Type          = reflect.TypeOf(int64(0))
 	uint64Type         = reflect.TypeOf(uint64(0))
+	bytesType          = reflect.TypeOf([]byte{})
 	stringSliceType    = reflect.TypeOf([]string{})
 	interfaceSliceType = reflect.TypeOf([]interface{}{})
 	mapInterfaceType   = reflect.TypeOf(map[string]interface{}{})
+
+	unmarshalerType     = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	marshalerType       = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	mapDecoderType      = reflect.TypeOf((*MapDecoder)(nil)).Elem()
+	mapEncoderType      = reflect.TypeOf((*MapEncoder)(nil)).Elem()
+
+	// Hooks which return values of the destination type unchanged, and
+	// skip maps and []interface{} values
+	passThroughHooks = map[uintptr]bool{
+		reflect.ValueOf(ConvertTime).Pointer():           true,
+		reflect.ValueOf(ConvertDuration).Pointer():       true,
+		reflect.ValueOf(ConvertQueryValues).Pointer():    true,
+		reflect.ValueOf(ConvertIntUint).Pointer():        true,
+		reflect.ValueOf(ConvertBytes).Pointer():          true,
+		reflect.ValueOf(ConvertStringToNumber).Pointer(): true,
+	}
 )
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -40,7 +87,31 @@ var (
 // Create a new decoder object with 'name' used as struct tag for interpreting
 // the field name
 func NewDecoder(name string, hooks ...UnmarshalScalarFunc) *Decoder {
-	return &Decoder{name, hooks}
+	return &Decoder{name: name, hooks: hooks, direct: passThrough(hooks...)}
+}
+
+// SetMaxErrors sets the maximum number of errors collected before decoding
+// stops, or zero to collect every error, and returns the decoder
+func (this *Decoder) SetMaxErrors(max int) *Decoder {
+	this.maxErrors = max
+	return this
+}
+
+// SetStrict sets strict mode, where decoding returns an error when any
+// source keys do not match a destination field, and returns the decoder
+func (this *Decoder) SetStrict(strict bool) *Decoder {
+	this.strict = strict
+	return this
+}
+
+// SetMerge sets merge mode, where decoding follows JSON Merge Patch (RFC 7386)
+// semantics for applying a partial update to an existing value, and returns
+// the decoder. Missing keys leave fields unchanged, nil values set fields to
+// the zero value, nested maps are merged into nested structures and maps, and
+// slices are replaced. Required and default values are not used
+func (this *Decoder) SetMerge(merge bool) *Decoder {
+	this.merge = merge
+	return this
 }
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -48,23 +119,80 @@ func NewDecoder(name string, hooks ...UnmarshalScalarFunc) *Decoder {
 
 // Decode decodes a map[string]interface{} type
 func (this *Decoder) Decode(src, dest interface{}) error {
+	_, err := this.decode(src, dest, this.strict)
+	return err
+}
 
-	if src == nil {
-		return ErrBadParameter.With("Decode: nil value")
+// DecodeMetadata decodes a map[string]interface{} type, and returns the
+// source keys which did not match any destination field without
+// returning an error for them
+func (this *Decoder) DecodeMetadata(src, dest interface{}) (*Metadata, error) {
+	return this.decode(src, dest, false)
+}
+
+// DecodeValue decodes a single value into dest, which should be a pointer.
+// The path identifies the value in any errors, and a nil source value
+// leaves the destination unchanged. When called from a MapDecoder, the
+// value is skipped once the maximum number of errors has been reached
+func (this *Decoder) DecodeValue(path string, src, dest interface{}) error {
+	d := reflect.ValueOf(dest)
+	if d.Kind() != reflect.Ptr || d.IsNil() {
+		return ErrBadParameter.With("DecodeValue: destination should be a pointer")
 	}
-	switch kind := reflect.ValueOf(src).Kind(); kind {
-	case reflect.Map:
-		return UnmarshalStruct(src, dest, this.name, this.unmarshalscalar)
-	case reflect.Slice:
-		return UnmarshalSlice(src, dest, this.unmarshalscalar)
-	default:
-		return ErrBadParameter.With("Decode: unable to decode ", kind)
+	if u := this.state; u != nil {
+		if u.done() {
+			return nil
+		} else if err := u.unmarshalValue(reflect.ValueOf(src), d.Elem(), path); err != nil {
+			return u.appendError(nil, err)
+		}
+		return nil
+	}
+	u := &unmarshaler{name: this.name, fn: this.unmarshalscalar, direct: this.direct, merge: this.merge, max: this.maxErrors}
+	return u.unmarshalValue(reflect.ValueOf(src), d.Elem(), path)
+}
+
+// DecodeMap decodes a map into dest, for example a nested structure within
+// a MapDecoder. The path identifies the map in any errors, and when dest
+// has a different tag name or type it is decoded with reflection
+func (this *Decoder) DecodeMap(path string, src map[string]interface{}, dest MapDecoder) error {
+	if !hasMapMethods(dest, elemType(reflect.TypeOf(dest)), this.name) {
+		return this.DecodeValue(path, src, dest)
+	}
+	u := this.state
+	if u == nil {
+		u = &unmarshaler{name: this.name, fn: this.unmarshalscalar, direct: this.direct, max: this.maxErrors}
+	} else if u.done() {
+		return nil
 	}
+	return u.decodeMap(src, dest, path)
+}
+
+// RequiredError returns an error for a required key which is missing from
+// the source, where dest is a pointer to the field. When called from a
+// MapDecoder, nil is returned once the maximum number of errors has been
+// reached
+func (this *Decoder) RequiredError(key string, dest interface{}) error {
+	err := newFieldError(key, nil, reflect.TypeOf(dest).Elem(), ErrRequired)
+	if u := this.state; u != nil {
+		if u.done() {
+			return nil
+		}
+		return u.appendError(nil, err)
+	}
+	return err
+}
+
+// PassThrough returns true when the hooks return values which already have
+// the destination type unchanged, so a MapDecoder can assign them directly
+// and decode nested maps and []interface{} values without DecodeValue
+func (this *Decoder) PassThrough() bool {
+	return this.direct
 }
 
 // DecodeQuery decodes a url.Values type
 func (this *Decoder) DecodeQuery(src url.Values, dest interface{}) error {
-	return UnmarshalStruct(src, dest, this.name, this.unmarshalscalar)
+	_, err := this.decode(src, dest, this.strict)
+	return err
 }
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -94,7 +222,7 @@ func ConvertTime(v reflect.Value, dest reflect.Type) (reflect.Value, error) {
 	if t, err := time.Parse(time.RFC3339Nano, v.String()); err == nil {
 		return reflect.ValueOf(t), nil
 	} else {
-		return nilValue, err
+		return nilValue, ErrParse.Wrap(err)
 	}
 }
 
@@ -121,10 +249,10 @@ func ConvertDuration(v reflect.Value, dest reflect.Type) (reflect.Value, error)
 		} else if v_, err := strconv.ParseUint(v.String(), 0, 64); err == nil {
 			return reflect.ValueOf(time.Duration(v_) * time.Second), nil
 		} else {
-			return nilValue, fmt.Errorf("cannot convert %q to time.Duration", v.String())
+			return nilValue, ErrParse.With("cannot convert ", strconv.Quote(v.String()), " to time.Duration")
 		}
 	}
-	return nilValue, fmt.Errorf("cannot convert %q to time.Duration", v.Kind())
+	return nilValue, ErrTypeMismatch.With("cannot convert ", v.Kind(), " to time.Duration")
 }
 
 // ConvertQueryValues returns a value from a []string
@@ -137,80 +265,81 @@ func ConvertQueryValues(v reflect.Value, dest reflect.Type) (reflect.Value, erro
 	if v.Len() == 0 {
 		return reflect.Zero(dest), nil
 	}
-	// Support conversions to scalars and slices
-	if dest.Kind() == reflect.Slice {
+	// Support conversions to scalars and slices, where slice types which
+	// implement encoding.TextUnmarshaler (for example, net.IP) are scalars
+	if dest.Kind() == reflect.Slice && !reflect.PtrTo(dest).Implements(textUnmarshalerType) {
 		return v, nil
 	} else if v.Len() == 1 {
 		return v.Index(0), nil
 	}
 	// Cannot convert
-	return nilValue, fmt.Errorf("cannot convert %q to %q", v, dest)
+	return nilValue, ErrTypeMismatch.With("cannot convert ", v, " to ", dest)
 }
 
-// ConvertIntUint allows conversion from int value to a different int value,
-// and uint value to a different uint value
+// ConvertIntUint allows conversion from int or int64 value to a different int
+// value, and uint or uint64 value to a different uint value, for example
+// int64 values from database drivers
 func ConvertIntUint(v reflect.Value, dest reflect.Type) (reflect.Value, error) {
 	// Skip this hook if type is not int or uint
-	if v.Type() != intType && v.Type() != uintType {
+	switch v.Type() {
+	case intType, uintType, int64Type, uint64Type:
+		break
+	default:
 		return nilValue, nil
 	}
 	// No conversion needed if destination is int or uint
 	if v.Type() == dest {
 		return v, nil
 	}
-	// Skip if can't convert
-	if !v.CanConvert(dest) {
+	// Skip if can't convert, or destination is an interface
+	if !v.CanConvert(dest) || dest.Kind() == reflect.Interface {
 		return nilValue, nil
 	}
-	// Check for bounds
+	// Check for bounds, converting between signed and unsigned values
+	zero := reflect.Zero(dest)
 	switch dest.Kind() {
-	case reflect.Int, reflect.Uint, reflect.Int64, reflect.Uint64:
-		if v.CanConvert(dest) {
-			return v.Convert(dest), nil
-		}
-	case reflect.Int8:
-		if v.Int() >= math.MinInt8 && v.Int() <= math.MaxInt8 {
-			return v.Convert(dest), nil
-		} else {
-			return nilValue, fmt.Errorf("value %v out of bounds for %v", v, dest)
-		}
-	case reflect.Uint8:
-		if v.Uint() <= math.MaxUint8 {
-			return v.Convert(dest), nil
-		} else {
-			return nilValue, fmt.Errorf("value %v out of bounds for %v", v, dest)
-		}
-	case reflect.Int16:
-		if v.Int() >= math.MinInt16 && v.Int() <= math.MaxInt16 {
-			return v.Convert(dest), nil
-		} else {
-			return nilValue, fmt.Errorf("value %v out of bounds for %v", v, dest)
-		}
-	case reflect.Uint16:
-		if v.Uint() <= math.MaxUint16 {
-			return v.Convert(dest), nil
-		} else {
-			return nilValue, fmt.Errorf("value %v out of bounds for %v", v, dest)
-		}
-	case reflect.Int32:
-		if v.Int() >= math.MinInt32 && v.Int() <= math.MaxInt32 {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Kind() == reflect.Uint || v.Kind() == reflect.Uint64 {
+			if value := v.Uint(); value <= math.MaxInt64 && !zero.OverflowInt(int64(value)) {
+				return v.Convert(dest), nil
+			}
+		} else if !zero.OverflowInt(v.Int()) {
 			return v.Convert(dest), nil
-		} else {
-			return nilValue, fmt.Errorf("value %v out of bounds for %v", v, dest)
 		}
-	case reflect.Uint32:
-		if v.Uint() <= math.MaxUint32 {
+		return nilValue, ErrOutOfRange.With("value ", v, " out of bounds for ", dest)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Kind() == reflect.Int || v.Kind() == reflect.Int64 {
+			if value := v.Int(); value >= 0 && !zero.OverflowUint(uint64(value)) {
+				return v.Convert(dest), nil
+			}
+		} else if !zero.OverflowUint(v.Uint()) {
 			return v.Convert(dest), nil
-		} else {
-			return nilValue, fmt.Errorf("value %v out of bounds for %v", v, dest)
 		}
+		return nilValue, ErrOutOfRange.With("value ", v, " out of bounds for ", dest)
 	}
 
 	// Cannot convert
-	return nilValue, fmt.Errorf("cannot convert %q to %q", v.Type(), dest)
+	return nilValue, ErrTypeMismatch.With("cannot convert ", v.Type(), " to ", dest)
 }
 
-// ConvertStringToNumber returns int, uint,float or bool from string
+// ConvertBytes returns a string from a []byte value, for example values from
+// database drivers, unless the destination is a byte slice or an interface
+func ConvertBytes(v reflect.Value, dest reflect.Type) (reflect.Value, error) {
+	// Skip this hook if source is not []byte
+	if v.Type() != bytesType {
+		return nilValue, nil
+	}
+	// Skip if destination is a byte slice or interface
+	if dest.Kind() == reflect.Interface || (dest.Kind() == reflect.Slice && dest.Elem().Kind() == reflect.Uint8) {
+		return nilValue, nil
+	}
+	return reflect.ValueOf(string(v.Bytes())), nil
+}
+
+// ConvertStringToNumber returns int, uint, float or bool from string. The
+// string is parsed for the size of the destination, and returns ErrOutOfRange
+// if the value does not fit. Strings which cannot be parsed are skipped, so
+// they can be converted by other functions
 func ConvertStringToNumber(v reflect.Value, dest reflect.Type) (reflect.Value, error) {
 	// Pass value through
 	if v.Type() == dest {
@@ -221,26 +350,28 @@ func ConvertStringToNumber(v reflect.Value, dest reflect.Type) (reflect.Value, e
 		return nilValue, nil
 	}
 	// Convert to int, uint, float or bool
+	var value interface{}
+	var err error
 	switch dest.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		if value, err := strconv.ParseInt(v.String(), 0, 64); err == nil {
-			return reflect.ValueOf(value).Convert(dest), nil
-		}
+		value, err = strconv.ParseInt(v.String(), 0, dest.Bits())
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		if value, err := strconv.ParseUint(v.String(), 0, 64); err == nil {
-			return reflect.ValueOf(value).Convert(dest), nil
-		}
+		value, err = strconv.ParseUint(v.String(), 0, dest.Bits())
 	case reflect.Float32, reflect.Float64:
-		if value, err := strconv.ParseFloat(v.String(), 64); err == nil {
-			return reflect.ValueOf(value).Convert(dest), nil
-		}
+		value, err = strconv.ParseFloat(v.String(), dest.Bits())
 	case reflect.Bool:
-		if value, err := strconv.ParseBool(v.String()); err == nil {
-			return reflect.ValueOf(value).Convert(dest), nil
-		}
+		value, err = strconv.ParseBool(v.String())
+	default:
+		// Skip
+		return nilValue, nil
+	}
+	if errors.Is(err, strconv.ErrRange) {
+		return nilValue, ErrOutOfRange.With("value ", v, " out of bounds for ", dest)
+	} else if err != nil {
+		// Skip
+		return nilValue, nil
 	}
-	// Skip
-	return nilValue, nil
+	return reflect.ValueOf(value).Convert(dest), nil
 }
 
 // ConvertMapInterface returns map[string]<type> from map[string]interface{} when all types
@@ -262,7 +393,7 @@ func ConvertMapInterface(v reflect.Value, dest reflect.Type) (reflect.Value, err
 			elem = reflect.ValueOf(elem.Interface())
 		}
 		if elem.Type() != dest.Elem() {
-			return nilValue, fmt.Errorf("value of type %v in map cannot be converted to %v", elem.Type(), dest.Elem())
+			return nilValue, ErrTypeMismatch.With("value of type ", elem.Type(), " in map cannot be converted to ", dest.Elem())
 		} else {
 			d.SetMapIndex(key, elem)
 		}
@@ -275,6 +406,49 @@ func ConvertMapInterface(v reflect.Value, dest reflect.Type) (reflect.Value, err
 ///////////////////////////////////////////////////////////////////////////////
 // PRIVATE METHODS
 
+// decode decodes a map or slice, and returns an error for unused keys
+// if strict is true
+func (this *Decoder) decode(src, dest interface{}, strict bool) (*Metadata, error) {
+	if src == nil {
+		return nil, ErrBadParameter.With("Decode: nil value")
+	}
+	u := &unmarshaler{name: this.name, fn: this.unmarshalscalar, direct: this.direct, track: true, merge: this.merge, max: this.maxErrors}
+	var result Errors
+	switch kind := reflect.ValueOf(src).Kind(); kind {
+	case reflect.Map:
+		if err := u.unmarshal(src, dest); err != nil {
+			result = u.appendError(result, err)
+		}
+	case reflect.Slice:
+		if err := u.unmarshalSlice(src, dest); err != nil {
+			result = u.appendError(result, err)
+		}
+	default:
+		return nil, ErrBadParameter.With("Decode: unable to decode ", kind)
+	}
+	sort.Strings(u.unused)
+	if strict {
+		for _, path := range u.unused {
+			if u.done() {
+				break
+			}
+			result = u.appendError(result, newFieldError(path, nil, nil, ErrUnknownField))
+		}
+	}
+	return &Metadata{Unused: u.unused}, result.errorOrNil()
+}
+
+// passThrough returns true if all hooks return values of the destination
+// type unchanged
+func passThrough(hooks ...UnmarshalScalarFunc) bool {
+	for _, hook := range hooks {
+		if hook != nil && !passThroughHooks[reflect.ValueOf(hook).Pointer()] {
+			return false
+		}
+	}
+	return true
+}
+
 func (this *Decoder) unmarshalscalar(v reflect.Value, dest reflect.Type) (reflect.Value, error) {
 	if !v.IsValid() {
 		return nilValue, nil
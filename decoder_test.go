@@ -1,7 +1,14 @@
 package marshaler_test
 
 import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
 	"net/url"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -147,3 +154,580 @@ func Test_Decoder_009(t *testing.T) {
 		t.Logf("%+v => %+v", src, dest)
 	}
 }
+
+type server struct {
+	Host    string        `yaml:"host"`
+	Port    uint16        `yaml:"port"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type config struct {
+	Name    string             `yaml:"name"`
+	Primary server             `yaml:"primary"`
+	Backup  *server            `yaml:"backup"`
+	Servers []server           `yaml:"servers"`
+	Named   map[string]*server `yaml:"named"`
+	Any     interface{}        `yaml:"any"`
+}
+
+func Test_Decoder_010(t *testing.T) {
+	src := map[string]interface{}{
+		"name": "test",
+		"primary": map[string]interface{}{
+			"host": "a", "port": "80", "timeout": "1s",
+		},
+		"backup": map[string]interface{}{
+			"host": "b", "port": 81, "timeout": 2,
+		},
+		"servers": []interface{}{
+			map[string]interface{}{"host": "c", "port": "82"},
+			map[string]interface{}{"host": "d", "timeout": "3s"},
+		},
+		"named": map[string]interface{}{
+			"e": map[string]interface{}{"host": "e"},
+		},
+		"any": []interface{}{1, "2"},
+	}
+	expected := config{
+		Name:    "test",
+		Primary: server{"a", 80, time.Second},
+		Backup:  &server{"b", 81, 2 * time.Second},
+		Servers: []server{{"c", 82, 0}, {"d", 0, 3 * time.Second}},
+		Named:   map[string]*server{"e": {"e", 0, 0}},
+		Any:     []interface{}{1, "2"},
+	}
+	var dest config
+	dec := marshaler.NewDecoder("yaml", marshaler.ConvertDuration, marshaler.ConvertStringToNumber, marshaler.ConvertIntUint)
+	if err := dec.Decode(src, &dest); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(dest, expected) {
+		t.Errorf("Unexpected value %+v != %+v", dest, expected)
+	}
+}
+
+func Test_Decoder_011(t *testing.T) {
+	src := config{
+		Name:    "test",
+		Primary: server{"a", 80, time.Second},
+		Backup:  &server{"b", 81, 2 * time.Second},
+		Servers: []server{{"c", 82, 0}},
+		Named:   map[string]*server{"e": {"e", 0, 0}},
+	}
+	var dest config
+	if m, err := marshaler.NewEncoder("yaml").Encode(src); err != nil {
+		t.Fatal(err)
+	} else if err := marshaler.NewDecoder("yaml").Decode(m, &dest); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(dest, src) {
+		t.Errorf("Unexpected round trip %+v != %+v", dest, src)
+	}
+}
+
+func Test_Decoder_012(t *testing.T) {
+	src := []interface{}{
+		map[string]interface{}{"host": "a", "port": 80},
+		map[string]interface{}{"host": "b", "port": "x"},
+	}
+	var dest []server
+	if err := marshaler.NewDecoder("yaml", marshaler.ConvertIntUint).Decode(src, &dest); err == nil {
+		t.Error("Expected error")
+	}
+}
+
+func Test_Decoder_013(t *testing.T) {
+	src := map[string]interface{}{
+		"A": map[string]interface{}{"a": 1, "b": "2"},
+		"B": int(-1),
+		"C": uint(300),
+	}
+	dest := struct {
+		A map[string]interface{}
+		B uint8
+		C int8
+	}{}
+	dec := marshaler.NewDecoder("yaml", marshaler.ConvertIntUint)
+	if err := dec.Decode(map[string]interface{}{"A": src["A"]}, &dest); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(dest.A, src["A"]) {
+		t.Errorf("Unexpected value %+v != %+v", dest.A, src["A"])
+	}
+	if err := dec.Decode(map[string]interface{}{"B": src["B"]}, &dest); err == nil {
+		t.Error("Expected out of bounds error")
+	}
+	if err := dec.Decode(map[string]interface{}{"C": src["C"]}, &dest); err == nil {
+		t.Error("Expected out of bounds error")
+	}
+}
+
+func Test_Decoder_014(t *testing.T) {
+	src := map[string]interface{}{
+		"name":   "test",
+		"timout": "1s",
+		"primary": map[string]interface{}{
+			"host": "a", "prot": 80,
+		},
+		"servers": []interface{}{
+			map[string]interface{}{"host": "c"},
+			map[string]interface{}{"hots": "d"},
+		},
+	}
+	var dest config
+	if err := marshaler.NewDecoder("yaml").Decode(src, &dest); err != nil {
+		t.Fatal(err)
+	}
+	if err := marshaler.NewDecoder("yaml").SetStrict(true).Decode(src, &dest); err == nil {
+		t.Fatal("Expected error")
+	} else if !strings.Contains(err.Error(), "primary.prot") || !strings.Contains(err.Error(), "servers[1].hots") {
+		t.Error("Unexpected error", err)
+	}
+	if meta, err := marshaler.NewDecoder("yaml").SetStrict(true).DecodeMetadata(src, &dest); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(meta.Unused, []string{"primary.prot", "servers[1].hots", "timout"}) {
+		t.Error("Unexpected unused keys", meta.Unused)
+	}
+}
+
+type celsius float64
+
+func (c *celsius) UnmarshalValue(src interface{}) error {
+	switch v := src.(type) {
+	case float64:
+		*c = celsius(v)
+	case string:
+		if _, err := fmt.Sscanf(v, "%fC", (*float64)(c)); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("cannot convert %T to celsius", src)
+	}
+	return nil
+}
+
+func (c celsius) MarshalValue() (interface{}, error) {
+	return fmt.Sprintf("%gC", float64(c)), nil
+}
+
+type textTypes struct {
+	IP    net.IP     `yaml:"ip"`
+	Big   big.Int    `yaml:"big"`
+	Ptr   *big.Int   `yaml:"ptr"`
+	Level slog.Level `yaml:"level"`
+	Temp  celsius    `yaml:"temp"`
+	Temps []celsius  `yaml:"temps"`
+}
+
+func Test_Decoder_015(t *testing.T) {
+	src := map[string]interface{}{
+		"ip":    "192.168.0.1",
+		"big":   "123456789012345678901234567890",
+		"ptr":   []byte("-5"),
+		"level": "WARN",
+		"temp":  "21.5C",
+		"temps": []interface{}{float64(1), "2C"},
+	}
+	var dest textTypes
+	if err := marshaler.NewDecoder("yaml").Decode(src, &dest); err != nil {
+		t.Fatal(err)
+	} else if !dest.IP.Equal(net.IPv4(192, 168, 0, 1)) {
+		t.Error("Unexpected ip", dest.IP)
+	} else if dest.Big.String() != src["big"] || dest.Ptr == nil || dest.Ptr.Int64() != -5 {
+		t.Error("Unexpected big", dest.Big, dest.Ptr)
+	} else if dest.Level != slog.LevelWarn {
+		t.Error("Unexpected level", dest.Level)
+	} else if dest.Temp != 21.5 || !reflect.DeepEqual(dest.Temps, []celsius{1, 2}) {
+		t.Error("Unexpected temperature", dest.Temp, dest.Temps)
+	}
+	if err := marshaler.NewDecoder("yaml").Decode(map[string]interface{}{"ip": "x"}, &dest); err == nil {
+		t.Error("Expected error")
+	}
+}
+
+func Test_Decoder_016(t *testing.T) {
+	src := textTypes{
+		IP:    net.IPv4(10, 0, 0, 1),
+		Ptr:   big.NewInt(42),
+		Level: slog.LevelError,
+		Temp:  celsius(3.5),
+		Temps: []celsius{1},
+	}
+	src.Big.SetInt64(-1)
+	expected := map[string]interface{}{
+		"ip":    "10.0.0.1",
+		"big":   "-1",
+		"ptr":   "42",
+		"level": "ERROR",
+		"temp":  "3.5C",
+		"temps": []interface{}{"1C"},
+	}
+	m, err := marshaler.NewEncoder("yaml").Encode(src)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m, expected) {
+		t.Errorf("Unexpected encoding %+v != %+v", m, expected)
+	}
+	var dest textTypes
+	if err := marshaler.NewDecoder("yaml").Decode(m, &dest); err != nil {
+		t.Fatal(err)
+	} else if !dest.IP.Equal(src.IP) || dest.Big.Cmp(&src.Big) != 0 || dest.Ptr.Cmp(src.Ptr) != 0 || dest.Level != src.Level || dest.Temp != src.Temp {
+		t.Errorf("Unexpected round trip %+v != %+v", dest, src)
+	}
+}
+
+func Test_Decoder_017(t *testing.T) {
+	src := map[string]interface{}{
+		"servers": []interface{}{
+			map[string]interface{}{"port": 80},
+			map[string]interface{}{"port": 81},
+			map[string]interface{}{"port": "http"},
+		},
+	}
+	var dest config
+	var fieldErr *marshaler.FieldError
+	err := marshaler.NewDecoder("yaml", marshaler.ConvertIntUint).Decode(src, &dest)
+	if err == nil {
+		t.Fatal("Expected error")
+	} else if !errors.As(err, &fieldErr) {
+		t.Fatal("Expected FieldError", err)
+	} else if fieldErr.Path != "servers[2].port" || fieldErr.Key != "port" {
+		t.Error("Unexpected path", fieldErr.Path, fieldErr.Key)
+	} else if fieldErr.Src != reflect.TypeOf("") || fieldErr.Dest != reflect.TypeOf(uint16(0)) {
+		t.Error("Unexpected types", fieldErr.Src, fieldErr.Dest)
+	} else if !errors.Is(err, marshaler.ErrTypeMismatch) {
+		t.Error("Expected ErrTypeMismatch", err)
+	} else if err.Error() != "servers[2].port: cannot decode string into uint16: ErrTypeMismatch" {
+		t.Error("Unexpected error", err)
+	}
+}
+
+func Test_Decoder_018(t *testing.T) {
+	src := map[string]interface{}{
+		"primary": map[string]interface{}{"timeout": "forever"},
+	}
+	var dest config
+	var fieldErr *marshaler.FieldError
+	if err := marshaler.NewDecoder("yaml", marshaler.ConvertDuration).Decode(src, &dest); err == nil {
+		t.Fatal("Expected error")
+	} else if !errors.As(err, &fieldErr) {
+		t.Fatal("Expected FieldError", err)
+	} else if fieldErr.Path != "primary.timeout" || fieldErr.Dest != reflect.TypeOf(time.Duration(0)) {
+		t.Error("Unexpected error", fieldErr)
+	}
+}
+
+func Test_Decoder_019(t *testing.T) {
+	src := make([]interface{}, 500)
+	for i := range src {
+		src[i] = map[string]interface{}{"host": fmt.Sprint("host", i), "port": i}
+	}
+	src[10] = map[string]interface{}{"port": "x"}
+	src[20] = map[string]interface{}{"port": -1}
+	src[30] = map[string]interface{}{"host": 30, "port": "y"}
+
+	var dest []server
+	var errs marshaler.Errors
+	if err := marshaler.NewDecoder("yaml", marshaler.ConvertIntUint).Decode(src, &dest); err == nil {
+		t.Fatal("Expected error")
+	} else if !errors.As(err, &errs) {
+		t.Fatal("Expected Errors", err)
+	} else if len(errs) != 4 {
+		t.Fatal("Expected four errors", errs)
+	} else {
+		paths := []string{}
+		for _, err := range errs {
+			var fieldErr *marshaler.FieldError
+			if !errors.As(err, &fieldErr) {
+				t.Fatal("Expected FieldError", err)
+			}
+			paths = append(paths, fieldErr.Path)
+		}
+		if !reflect.DeepEqual(paths, []string{"[10].port", "[20].port", "[30].host", "[30].port"}) {
+			t.Error("Unexpected paths", paths)
+		}
+	}
+	if dest[499].Host != "host499" {
+		t.Error("Expected all rows decoded", dest[499])
+	}
+	if err := marshaler.NewDecoder("yaml", marshaler.ConvertIntUint).SetMaxErrors(2).Decode(src, &dest); !errors.As(err, &errs) {
+		t.Fatal("Expected Errors", err)
+	} else if len(errs) != 2 {
+		t.Error("Expected two errors", errs)
+	}
+}
+
+func Test_Decoder_020(t *testing.T) {
+	type ts struct {
+		Int8     int8          `yaml:"int8"`
+		Duration time.Duration `yaml:"duration"`
+		Time     time.Time     `yaml:"time"`
+		IP       net.IP        `yaml:"ip"`
+		Func     func()        `yaml:"func"`
+		Required string        `yaml:"required,required"`
+	}
+	tests := []struct {
+		src map[string]interface{}
+		err error
+	}{
+		{map[string]interface{}{"int8": 1000}, marshaler.ErrOutOfRange},
+		{map[string]interface{}{"int8": "x"}, marshaler.ErrTypeMismatch},
+		{map[string]interface{}{"duration": "x"}, marshaler.ErrParse},
+		{map[string]interface{}{"duration": 1.5}, marshaler.ErrTypeMismatch},
+		{map[string]interface{}{"time": "x"}, marshaler.ErrParse},
+		{map[string]interface{}{"ip": "x"}, marshaler.ErrParse},
+		{map[string]interface{}{"func": "x"}, marshaler.ErrUnsupported},
+		{map[string]interface{}{}, marshaler.ErrRequired},
+		{map[string]interface{}{"other": "x"}, marshaler.ErrUnknownField},
+	}
+	dec := marshaler.NewDecoder("yaml", marshaler.ConvertIntUint, marshaler.ConvertDuration, marshaler.ConvertTime).SetStrict(true)
+	for _, test := range tests {
+		var dest ts
+		if _, exists := test.src["required"]; !exists && test.err != marshaler.ErrRequired {
+			test.src["required"] = "x"
+		}
+		if err := dec.Decode(test.src, &dest); !errors.Is(err, test.err) {
+			t.Errorf("Expected %v for %v, got %v", test.err, test.src, err)
+		}
+	}
+}
+
+// point implements the generated DecodeMap, EncodeMap, MarshalerTag and
+// MarshalerType methods
+type point struct {
+	X, Y int
+}
+
+func (p point) MarshalerTag() string {
+	return "yaml"
+}
+
+func (p point) MarshalerType() interface{} {
+	return (*point)(nil)
+}
+
+func (p *point) DecodeMap(dec *marshaler.Decoder, src map[string]interface{}) error {
+	var result marshaler.Errors
+	for _, field := range []struct {
+		key  string
+		dest *int
+	}{{"x", &p.X}, {"y", &p.Y}} {
+		if value, exists := src[field.key]; !exists {
+			if err := dec.RequiredError(field.key, field.dest); err != nil {
+				result = result.Append(err)
+			}
+		} else if err := dec.DecodeValue(field.key, value, field.dest); err != nil {
+			result = result.Append(err)
+		}
+	}
+	if len(result) > 0 {
+		return result
+	}
+	return nil
+}
+
+func (p point) EncodeMap(enc *marshaler.Encoder) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, 2)
+	for key, value := range map[string]int{"x": p.X, "y": p.Y} {
+		if value, err := enc.EncodeValue(value); err != nil {
+			return nil, err
+		} else {
+			result[key] = value
+		}
+	}
+	return result, nil
+}
+
+func Test_Decoder_021(t *testing.T) {
+	type shape struct {
+		Points []point `yaml:"points"`
+	}
+	var dest shape
+	src := map[string]interface{}{
+		"points": []interface{}{
+			map[string]interface{}{"x": 1, "y": 2},
+			map[string]interface{}{"x": 3, "z": 4},
+		},
+	}
+	err := marshaler.NewDecoder("yaml").Decode(src, &dest)
+	var errs marshaler.Errors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("Unexpected %v", err)
+	}
+	var fieldErr *marshaler.FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Path != "points[1].y" {
+		t.Errorf("Unexpected %v", err)
+	}
+	if len(dest.Points) != 2 || dest.Points[0] != (point{1, 2}) {
+		t.Errorf("Unexpected %v", dest)
+	}
+
+	// Methods for a different tag name are not used
+	var other shape
+	if err := marshaler.NewDecoder("json").Decode(map[string]interface{}{"Points": []interface{}{map[string]interface{}{"X": 5}}}, &other); err != nil {
+		t.Fatal(err)
+	} else if other.Points[0] != (point{5, 0}) {
+		t.Errorf("Unexpected %v", other)
+	}
+}
+
+func Test_Decoder_022(t *testing.T) {
+	// Merge mode follows JSON Merge Patch semantics
+	type address struct {
+		Host string `json:"host"`
+		Port int    `json:"port,default=80"`
+	}
+	type doc struct {
+		Title   string                 `json:"title,required"`
+		Author  *address               `json:"author"`
+		Address address                `json:"address"`
+		Tags    []string               `json:"tags"`
+		Labels  map[string]string      `json:"labels"`
+		Extra   map[string]interface{} `json:"extra"`
+		Count   int                    `json:"count"`
+	}
+	dest := doc{
+		Title:   "Goodbye!",
+		Author:  &address{Host: "a", Port: 1},
+		Address: address{Host: "b", Port: 2},
+		Tags:    []string{"a", "b"},
+		Labels:  map[string]string{"x": "1", "y": "2"},
+		Extra:   map[string]interface{}{"nested": map[string]interface{}{"a": 1, "b": 2}},
+		Count:   3,
+	}
+	src := map[string]interface{}{
+		"title":   "Hello!",
+		"author":  nil,
+		"address": map[string]interface{}{"port": 8080},
+		"tags":    []interface{}{"c"},
+		"labels":  map[string]interface{}{"x": nil, "z": "3"},
+		"extra":   map[string]interface{}{"nested": map[string]interface{}{"a": nil, "c": 3}},
+	}
+	if err := marshaler.NewDecoder("json").SetMerge(true).Decode(src, &dest); err != nil {
+		t.Fatal(err)
+	}
+	expected := doc{
+		Title:   "Hello!",
+		Address: address{Host: "b", Port: 8080},
+		Tags:    []string{"c"},
+		Labels:  map[string]string{"y": "2", "z": "3"},
+		Extra:   map[string]interface{}{"nested": map[string]interface{}{"b": 2, "c": 3}},
+		Count:   3,
+	}
+	if !reflect.DeepEqual(dest, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, dest)
+	}
+
+	// Nil values set fields to the zero value, and missing required
+	// values are not an error
+	if err := marshaler.NewDecoder("json").SetMerge(true).Decode(map[string]interface{}{"count": nil, "address": nil}, &dest); err != nil {
+		t.Fatal(err)
+	} else if dest.Count != 0 || dest.Address != (address{}) || dest.Title != "Hello!" {
+		t.Errorf("Unexpected %+v", dest)
+	}
+}
+
+func Test_Decoder_023(t *testing.T) {
+	// Merge into a map
+	dest := map[string]interface{}{"a": 1, "b": map[string]interface{}{"c": 2, "d": 3}}
+	src := map[string]interface{}{"a": nil, "b": map[string]interface{}{"c": nil, "e": 4}, "f": 5}
+	if err := marshaler.NewDecoder("json").SetMerge(true).Decode(src, &dest); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"b": map[string]interface{}{"d": 3, "e": 4}, "f": 5}
+	if !reflect.DeepEqual(dest, expected) {
+		t.Errorf("Expected %v, got %v", expected, dest)
+	}
+}
+
+type level int
+
+// levelHook converts level names into levels
+func levelHook(v reflect.Value, dest reflect.Type) (reflect.Value, error) {
+	if v.Kind() != reflect.String || dest != reflect.TypeOf(level(0)) {
+		return reflect.Value{}, nil
+	}
+	switch v.String() {
+	case "low":
+		return reflect.ValueOf(level(1)), nil
+	case "high":
+		return reflect.ValueOf(level(2)), nil
+	}
+	return reflect.Value{}, nil
+}
+
+func Test_Decoder_024(t *testing.T) {
+	type ts struct {
+		Int8     int8          `yaml:"int8"`
+		Int      int           `yaml:"int"`
+		Uint8    uint8         `yaml:"uint8"`
+		Float32  float32       `yaml:"float32"`
+		Bool     bool          `yaml:"bool"`
+		Duration time.Duration `yaml:"duration"`
+	}
+	tests := []struct {
+		src map[string]interface{}
+		err error
+	}{
+		{map[string]interface{}{"int8": "300"}, marshaler.ErrOutOfRange},
+		{map[string]interface{}{"int8": "-129"}, marshaler.ErrOutOfRange},
+		{map[string]interface{}{"uint8": "256"}, marshaler.ErrOutOfRange},
+		{map[string]interface{}{"float32": "1e40"}, marshaler.ErrOutOfRange},
+		{map[string]interface{}{"int": "abc"}, marshaler.ErrTypeMismatch},
+		{map[string]interface{}{"uint8": "-1"}, marshaler.ErrTypeMismatch},
+		{map[string]interface{}{"bool": "x"}, marshaler.ErrTypeMismatch},
+	}
+	dec := marshaler.NewDecoder("yaml", marshaler.ConvertStringToNumber, marshaler.ConvertDuration)
+	for _, test := range tests {
+		var dest ts
+		if err := dec.Decode(test.src, &dest); !errors.Is(err, test.err) {
+			t.Errorf("Expected %v for %v, got %v", test.err, test.src, err)
+		}
+	}
+
+	// Values within range, and durations are left to ConvertDuration
+	var dest ts
+	src := map[string]interface{}{"int8": "-128", "int": "0x10", "uint8": "255", "float32": "1.5", "bool": "true", "duration": "5s"}
+	if err := dec.Decode(src, &dest); err != nil {
+		t.Fatal(err)
+	} else if dest != (ts{-128, 16, 255, 1.5, true, 5 * time.Second}) {
+		t.Errorf("Unexpected %+v", dest)
+	}
+
+	// Strings which cannot be parsed are left to later functions
+	var levels struct {
+		Level level `yaml:"level"`
+		Other level `yaml:"other"`
+	}
+	dec = marshaler.NewDecoder("yaml", marshaler.ConvertStringToNumber, levelHook)
+	if err := dec.Decode(map[string]interface{}{"level": "high", "other": "1"}, &levels); err != nil {
+		t.Fatal(err)
+	} else if levels.Level != 2 || levels.Other != 1 {
+		t.Errorf("Unexpected %+v", levels)
+	}
+}
+
+func Test_Decoder_025(t *testing.T) {
+	// Methods use the hooks and maximum number of errors of the calling decoder
+	type shape struct {
+		Points []point `yaml:"points"`
+	}
+	src := map[string]interface{}{
+		"points": []interface{}{map[string]interface{}{"x": "1", "y": "2"}},
+	}
+	var dest shape
+	if err := marshaler.NewDecoder("yaml").Decode(src, &dest); !errors.Is(err, marshaler.ErrTypeMismatch) {
+		t.Error("Expected ErrTypeMismatch, got", err)
+	}
+	if err := marshaler.NewDecoder("yaml", marshaler.ConvertStringToNumber).Decode(src, &dest); err != nil {
+		t.Fatal(err)
+	} else if dest.Points[0] != (point{1, 2}) {
+		t.Errorf("Unexpected %v", dest)
+	}
+	src = map[string]interface{}{
+		"points": []interface{}{map[string]interface{}{}, map[string]interface{}{}},
+	}
+	var errs marshaler.Errors
+	if err := marshaler.NewDecoder("yaml").SetMaxErrors(3).Decode(src, &dest); !errors.As(err, &errs) || len(errs) != 3 {
+		t.Errorf("Unexpected %v", err)
+	} else if errs[2].Error() != "points[1].x: ErrRequired" {
+		t.Errorf("Unexpected %v", errs[2])
+	}
+}
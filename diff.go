@@ -0,0 +1,109 @@
+package marshaler
+
+import (
+	"reflect"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// PUBLIC METHODS
+
+// Diff returns the fields which differ between two values of the same
+// structure (or pointer to structure), with the updated values encoded in
+// the same way as Encode. Nested structures are compared field by field, and
+// their changed fields are returned with dotted keys, for example
+// server.port. Values are compared with an Equal method where the type (or
+// pointer to the type) has one, for example time.Time
+func (this *Encoder) Diff(old, updated interface{}) (map[string]interface{}, error) {
+	a, b := reflect.ValueOf(old), reflect.ValueOf(updated)
+	// Fudge pointers
+	if a.Kind() == reflect.Ptr && b.Kind() == reflect.Ptr && !a.IsNil() && !b.IsNil() {
+		a, b = a.Elem(), b.Elem()
+	}
+	if a.Kind() != reflect.Struct || b.Kind() != reflect.Struct {
+		return nil, ErrBadParameter.With("Diff: expected struct but got ", a.Kind(), " and ", b.Kind())
+	} else if a.Type() != b.Type() {
+		return nil, ErrBadParameter.With("Diff: cannot compare ", a.Type(), " with ", b.Type())
+	}
+	result := make(map[string]interface{})
+	if err := this.diffStruct(result, "", a, b); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+// diffStruct adds the changed fields of two structures to the result
+func (this *Encoder) diffStruct(result map[string]interface{}, path string, a, b reflect.Value) error {
+	fa, fb := this.reflect(a, true), this.reflect(b, true)
+	for i, field := range fb {
+		if err := this.diffValue(result, joinPath(path, field.Name), fa[i].Value, field.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffValue adds the updated value to the result when the values differ,
+// recursing into pointers and structures
+func (this *Encoder) diffValue(result map[string]interface{}, path string, a, b reflect.Value) error {
+	switch {
+	case a.Kind() == reflect.Ptr:
+		if a.IsNil() && b.IsNil() {
+			return nil
+		} else if !a.IsNil() && !b.IsNil() {
+			return this.diffValue(result, path, a.Elem(), b.Elem())
+		}
+	case hasEqual(a.Type()):
+		if a.MethodByName("Equal").Call([]reflect.Value{b})[0].Bool() {
+			return nil
+		}
+	case hasEqual(reflect.PtrTo(a.Type())):
+		if addr(a).MethodByName("Equal").Call([]reflect.Value{addr(b)})[0].Bool() {
+			return nil
+		}
+	case a.Kind() == reflect.Struct && needsEncoding(a.Type()) && !hasMarshaler(a.Type()):
+		return this.diffStruct(result, path, a, b)
+	default:
+		if reflect.DeepEqual(a.Interface(), b.Interface()) {
+			return nil
+		}
+	}
+
+	// Set the changed value
+	value, err := this.encodeValue(b)
+	if err != nil {
+		return err
+	}
+	result[path] = value
+	return nil
+}
+
+// hasEqual returns true if a type has an Equal method which compares with
+// another value of the same type, for example time.Time
+func hasEqual(t reflect.Type) bool {
+	m, exists := t.MethodByName("Equal")
+	if !exists || t.Kind() == reflect.Interface {
+		return false
+	}
+	return m.Type.NumIn() == 2 && m.Type.In(1) == t && m.Type.NumOut() == 1 && m.Type.Out(0).Kind() == reflect.Bool
+}
+
+// addr returns a pointer to a value, or to a copy of the value when it is
+// not addressable
+func addr(v reflect.Value) reflect.Value {
+	if v.CanAddr() {
+		return v.Addr()
+	}
+	ptr := reflect.New(v.Type())
+	ptr.Elem().Set(v)
+	return ptr
+}
+
+// hasMarshaler returns true if a type implements Marshaler or
+// encoding.TextMarshaler, so is encoded as a single value
+func hasMarshaler(t reflect.Type) bool {
+	ptr := reflect.PtrTo(t)
+	return ptr.Implements(marshalerType) || ptr.Implements(textMarshalerType)
+}
@@ -0,0 +1,127 @@
+package marshaler_test
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/djthorpe/go-marshaler"
+)
+
+type diffAddress struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+type diffServer struct {
+	Name     string            `json:"name"`
+	Address  diffAddress       `json:"address"`
+	Backup   *diffAddress      `json:"backup"`
+	Created  time.Time         `json:"created"`
+	IP       net.IP            `json:"ip"`
+	Tags     []string          `json:"tags"`
+	Labels   map[string]string `json:"labels"`
+	Timeout  time.Duration     `json:"timeout"`
+	Internal string            `json:"-"`
+}
+
+type diffVersion struct {
+	N int `json:"n"`
+}
+
+func (this *diffVersion) Equal(other *diffVersion) bool {
+	return this.N == other.N
+}
+
+type diffRelease struct {
+	Version *diffVersion `json:"version"`
+	Latest  diffVersion  `json:"latest"`
+}
+
+func Test_Diff_001(t *testing.T) {
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	old := diffServer{
+		Name:    "server",
+		Address: diffAddress{"localhost", 80},
+		Created: created,
+		IP:      net.ParseIP("127.0.0.1"),
+		Tags:    []string{"a"},
+	}
+	updated := old
+	updated.Address.Port = 8080
+	updated.Created = created.In(time.FixedZone("X", 3600))
+	updated.IP = net.ParseIP("127.0.0.1").To4()
+	updated.Tags = []string{"a", "b"}
+	updated.Internal = "changed"
+	result, err := marshaler.NewEncoder("json").Diff(&old, &updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"address.port": 8080,
+		"tags":         []string{"a", "b"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+}
+
+func Test_Diff_002(t *testing.T) {
+	// Pointers to structures and encode hooks
+	old := diffServer{Backup: &diffAddress{"a", 1}}
+	updated := diffServer{Backup: &diffAddress{"b", 1}, Timeout: time.Second}
+	enc := marshaler.NewEncoder("json", marshaler.MarshalDuration)
+	if result, err := enc.Diff(old, updated); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(result, map[string]interface{}{"backup.host": "b", "timeout": "1s"}) {
+		t.Errorf("Unexpected %v", result)
+	}
+	updated.Backup = nil
+	if result, err := enc.Diff(old, updated); err != nil {
+		t.Fatal(err)
+	} else if v, exists := result["backup"]; !exists || v != nil {
+		t.Errorf("Unexpected %v", result)
+	}
+	if result, err := enc.Diff(updated, old); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(result["backup"], map[string]interface{}{"host": "a", "port": 1}) {
+		t.Errorf("Unexpected %v", result)
+	}
+}
+
+func Test_Diff_003(t *testing.T) {
+	enc := marshaler.NewEncoder("json")
+	if result, err := enc.Diff(diffServer{}, diffServer{}); err != nil {
+		t.Fatal(err)
+	} else if len(result) != 0 {
+		t.Errorf("Unexpected %v", result)
+	}
+	if _, err := enc.Diff(diffServer{}, diffAddress{}); !errors.Is(err, marshaler.ErrBadParameter) {
+		t.Errorf("Unexpected %v", err)
+	}
+	if _, err := enc.Diff(1, 2); !errors.Is(err, marshaler.ErrBadParameter) {
+		t.Errorf("Unexpected %v", err)
+	}
+}
+
+func Test_Diff_004(t *testing.T) {
+	// Equal methods with a pointer receiver
+	enc := marshaler.NewEncoder("json")
+	if result, err := enc.Diff(diffRelease{}, diffRelease{Version: &diffVersion{1}}); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(result, map[string]interface{}{"version": map[string]interface{}{"n": 1}}) {
+		t.Errorf("Unexpected %v", result)
+	}
+	if result, err := enc.Diff(diffRelease{&diffVersion{1}, diffVersion{1}}, diffRelease{&diffVersion{2}, diffVersion{1}}); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(result, map[string]interface{}{"version": map[string]interface{}{"n": 2}}) {
+		t.Errorf("Unexpected %v", result)
+	}
+	if result, err := enc.Diff(diffRelease{&diffVersion{1}, diffVersion{1}}, diffRelease{&diffVersion{1}, diffVersion{2}}); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(result, map[string]interface{}{"latest": map[string]interface{}{"n": 2}}) {
+		t.Errorf("Unexpected %v", result)
+	}
+}
@@ -1,20 +1,50 @@
 package marshaler
 
 import (
+	"encoding"
+	"net/url"
 	"reflect"
-	"strings"
-	"unicode"
+	"strconv"
+	"time"
 )
 
 ///////////////////////////////////////////////////////////////////////////////
 // TYPES
 
 type Encoder struct {
-	name string
+	name  string
+	hooks []MarshalScalarFunc
 }
 
+// Marshaler is implemented by types which encode themselves into a value
+type Marshaler interface {
+	MarshalValue() (interface{}, error)
+}
+
+// MapEncoder is implemented by types which encode themselves into a map
+// without reflection, for example by methods generated by marshalergen.
+// The calling encoder is passed so that values encoded with its EncodeValue
+// method use its hooks. If the type also has a MarshalerTag method, the
+// methods are only used by encoders with the same tag name, and if it has a
+// MarshalerType method which returns a nil pointer to the type, they are not
+// used for structures which embed it
+type MapEncoder interface {
+	EncodeMap(enc *Encoder) (map[string]interface{}, error)
+}
+
+// Custom function for converting a scalar value when encoding. The argument
+// is the source value, and an invalid value is returned to skip the conversion
+type MarshalScalarFunc func(reflect.Value) (reflect.Value, error)
+
 type Field struct {
+	// The index of the field in the structure, or the index of the embedded
+	// structure for a promoted field
 	Index int
+
+	// The index sequence of the field for reflect.Value.FieldByIndex, which
+	// should not be modified
+	IndexPath []int
+
 	Name  string
 	Type  reflect.Type
 	Value reflect.Value
@@ -24,17 +54,30 @@ type Field struct {
 ///////////////////////////////////////////////////////////////////////////////
 // LIFECYCLE
 
-// Create a new decoder object with 'name' used as struct tag for interpreting
-// the field name
-func NewEncoder(name string) *Encoder {
-	return &Encoder{name}
+// Create a new encoder object with 'name' used as struct tag for interpreting
+// the field name, and optional hooks for converting scalar values
+func NewEncoder(name string, hooks ...MarshalScalarFunc) *Encoder {
+	return &Encoder{name, hooks}
 }
 
 ///////////////////////////////////////////////////////////////////////////////
 // PUBLIC METHODS
 
+// HasTag returns true if the field has a tag option, for example "omitempty"
+func (f *Field) HasTag(tag string) bool {
+	for _, t := range f.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
 // Reflect on a structure (or pointer to structure) returns field names and
-// their tags or nil if any field is ignored
+// their tags, or nil if the argument is not a structure. Ignored and private
+// fields are not returned, so the position of a field in the result is not
+// its index in the structure. Fields of embedded structures without a tag name
+// are returned as fields of the outer structure, and IndexPath locates them
 func (this *Encoder) Reflect(v interface{}) []*Field {
 	rv := reflect.ValueOf(v)
 	// Fudge pointers
@@ -44,43 +87,473 @@ func (this *Encoder) Reflect(v interface{}) []*Field {
 	if rv.Kind() != reflect.Struct {
 		return nil
 	}
-	// Enumerate struct fields
-	var result []*Field
-	for i := 0; i < rv.Type().NumField(); i++ {
-		result = append(result, reflectField(rv.Type().Field(i), rv.Field(i), this.name))
+	return this.reflect(rv, true)
+}
+
+// Encode returns a map[string]interface{} from a structure (or pointer to
+// structure), recursing into nested structures, slices and maps. The result
+// can be decoded back into the structure with Decoder.Decode
+func (this *Encoder) Encode(v interface{}) (map[string]interface{}, error) {
+	rv := reflect.ValueOf(v)
+	// Fudge pointers
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, ErrBadParameter.With("Encode: expected struct but got ", rv.Kind())
 	}
-	return result
+	return this.encodeStruct(rv)
+}
+
+// EncodeValue returns a value encoded in the same way as the fields of a
+// structure by Encode
+func (this *Encoder) EncodeValue(v interface{}) (interface{}, error) {
+	return this.encodeValue(reflect.ValueOf(v))
+}
+
+// PassThrough returns true when there are no hooks, so a MapEncoder can
+// assign values directly and encode nested structures without EncodeValue
+func (this *Encoder) PassThrough() bool {
+	return len(this.hooks) == 0
+}
+
+// EncodeQuery returns url.Values from a structure (or pointer to structure).
+// Slices are encoded as repeated keys, and scalars are formatted so they can
+// be read back with Decoder.DecodeQuery
+func (this *Encoder) EncodeQuery(v interface{}) (url.Values, error) {
+	rv := reflect.ValueOf(v)
+	// Fudge pointers
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, ErrBadParameter.With("EncodeQuery: expected struct but got ", rv.Kind())
+	}
+	result := make(url.Values)
+	for _, field := range this.reflect(rv, false) {
+		if field.omit() {
+			continue
+		}
+		if err := this.encodeQueryValue(result, field.Name, field.Value, true); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// MARSHAL HOOKS
+
+// MarshalTime returns a time.Time value as a RFC3339 string, or an empty
+// string for zero-time. It is the inverse of ConvertTime
+func MarshalTime(v reflect.Value) (reflect.Value, error) {
+	// Skip this hook if type is not time type
+	if v.Type() != timeType {
+		return nilValue, nil
+	}
+	if t := v.Interface().(time.Time); t.IsZero() {
+		return reflect.ValueOf(""), nil
+	} else {
+		return reflect.ValueOf(t.Format(time.RFC3339Nano)), nil
+	}
+}
+
+// MarshalDuration returns a time.Duration value as a string. It is the
+// inverse of ConvertDuration
+func MarshalDuration(v reflect.Value) (reflect.Value, error) {
+	// Skip this hook if type is not duration type
+	if v.Type() != durationType {
+		return nilValue, nil
+	}
+	return reflect.ValueOf(time.Duration(v.Int()).String()), nil
+}
+
+// MarshalQueryValues returns a []string from a string. It is the inverse
+// of ConvertQueryValues
+func MarshalQueryValues(v reflect.Value) (reflect.Value, error) {
+	// Skip this hook if type is not string
+	if v.Kind() != reflect.String {
+		return nilValue, nil
+	}
+	return reflect.ValueOf([]string{v.String()}), nil
+}
+
+// MarshalIntUint returns an int from any signed integer, and a uint from
+// any unsigned integer. It is the inverse of ConvertIntUint
+func MarshalIntUint(v reflect.Value) (reflect.Value, error) {
+	switch v.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if value := v.Int(); int64(int(value)) == value {
+			return reflect.ValueOf(int(value)), nil
+		}
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if value := v.Uint(); uint64(uint(value)) == value {
+			return reflect.ValueOf(uint(value)), nil
+		}
+	}
+	// Skip
+	return nilValue, nil
+}
+
+// MarshalNumberToString returns a string from an int, uint, float or bool.
+// It is the inverse of ConvertStringToNumber
+func MarshalNumberToString(v reflect.Value) (reflect.Value, error) {
+	// Skip durations, which are formatted by MarshalDuration
+	if v.Type() == durationType {
+		return nilValue, nil
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.Bool:
+		if value, err := formatQueryValue(v); err != nil {
+			return nilValue, err
+		} else {
+			return reflect.ValueOf(value), nil
+		}
+	}
+	// Skip
+	return nilValue, nil
+}
+
+// MarshalMapInterface returns map[string]interface{} from map[string]<type>.
+// It is the inverse of ConvertMapInterface
+func MarshalMapInterface(v reflect.Value) (reflect.Value, error) {
+	// Skip this hook if source is not map[string]...
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		return nilValue, nil
+	}
+	// Pass value through
+	if v.Type() == mapInterfaceType || v.IsNil() {
+		return v, nil
+	}
+	d := reflect.MakeMapWithSize(mapInterfaceType, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		d.SetMapIndex(reflect.ValueOf(iter.Key().String()), iter.Value())
+	}
+
+	// Return converted map
+	return d, nil
 }
 
 ///////////////////////////////////////////////////////////////////////////////
 // PRIVATE METHODS
 
-func reflectField(field reflect.StructField, value reflect.Value, name string) *Field {
-	var result Field
+// encodeStruct returns a map of field names to encoded values
+func (this *Encoder) encodeStruct(rv reflect.Value) (map[string]interface{}, error) {
+	// Use generated methods if implemented
+	if enc := mapEncoder(rv); enc != nil && hasMapMethods(enc, rv.Type(), this.name) {
+		return enc.EncodeMap(this)
+	}
 
-	// Private or anonymous fields not supported
-	if field.Anonymous || unicode.IsLower(rune(field.Name[0])) {
-		return nil
+	result := make(map[string]interface{}, rv.NumField())
+	for _, field := range this.reflect(rv, false) {
+		if field.omit() {
+			continue
+		}
+		if v, err := this.encodeValue(field.Value); err != nil {
+			return nil, err
+		} else {
+			result[field.Name] = v
+		}
+	}
+	return result, nil
+}
+
+// encodeValue returns a value where nested structures are converted into
+// map[string]interface{}. Nil pointers, slices and maps are returned as nil.
+// When hooks are set, slices and maps are always converted element by element
+func (this *Encoder) encodeValue(v reflect.Value) (interface{}, error) {
+	// Convert scalar values, a value converted to a different type is returned
+	// unless it contains further values to encode
+	if value, err := this.marshalscalar(v); err != nil {
+		return nil, err
+	} else if value.IsValid() && value.Type() != v.Type() {
+		if !needsEncoding(value.Type()) {
+			return value.Interface(), nil
+		}
+		v = value
+	}
+
+	// Recurse into structures, slices and maps
+	elements := len(this.hooks) > 0
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil, nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		} else if v.Kind() == reflect.Interface || elements || needsEncoding(v.Type().Elem()) {
+			return this.encodeValue(v.Elem())
+		}
+	case reflect.Struct:
+		if needsEncoding(v.Type()) {
+			return this.encodeStruct(v)
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, nil
+		} else if elements || needsEncoding(v.Type().Elem()) {
+			result := make([]interface{}, v.Len())
+			for i := 0; i < v.Len(); i++ {
+				if elem, err := this.encodeValue(v.Index(i)); err != nil {
+					return nil, err
+				} else {
+					result[i] = elem
+				}
+			}
+			return result, nil
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			return nil, nil
+		} else if v.Type().Key().Kind() != reflect.String {
+			if needsEncoding(v.Type().Elem()) {
+				return nil, ErrUnsupported.With("Encode: map key should be string but got ", v.Type().Key())
+			}
+		} else if elements || needsEncoding(v.Type().Elem()) {
+			result := make(map[string]interface{}, v.Len())
+			iter := v.MapRange()
+			for iter.Next() {
+				if elem, err := this.encodeValue(iter.Value()); err != nil {
+					return nil, err
+				} else {
+					result[iter.Key().String()] = elem
+				}
+			}
+			return result, nil
+		}
+	}
+
+	// Return value as-is
+	return v.Interface(), nil
+}
+
+// omit returns true if the field should be omitted from the encoded output,
+// either with the "omitempty" option and an empty value, or with the "omitnil"
+// option and a nil value
+func (f *Field) omit() bool {
+	if f.HasTag("omitempty") && isEmptyValue(f.Value) {
+		return true
+	}
+	if f.HasTag("omitnil") && isNilValue(f.Value) {
+		return true
+	}
+	return false
+}
+
+// isEmptyValue returns true for false, zero, empty strings, slices and maps
+// and nil pointers, as for encoding/json
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// isNilValue returns true for nil pointers, interfaces, slices and maps
+func isNilValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+		return v.IsNil()
+	}
+	return false
+}
+
+// mapEncoder returns the MapEncoder for a structure, or nil if the structure
+// does not implement it
+func mapEncoder(rv reflect.Value) MapEncoder {
+	if rv.Type().Implements(mapEncoderType) {
+		return rv.Interface().(MapEncoder)
+	} else if reflect.PtrTo(rv.Type()).Implements(mapEncoderType) {
+		ptr := reflect.New(rv.Type())
+		ptr.Elem().Set(rv)
+		return ptr.Interface().(MapEncoder)
+	}
+	return nil
+}
+
+// marshalscalar converts a value which implements Marshaler or
+// encoding.TextMarshaler, and then calls each hook in turn to convert
+// the value
+func (this *Encoder) marshalscalar(v reflect.Value) (reflect.Value, error) {
+	if !v.IsValid() {
+		return v, nil
+	}
+	if value, err := marshalInterface(v); err != nil {
+		return nilValue, err
+	} else if value.IsValid() {
+		v = value
+	}
+	for _, hook := range this.hooks {
+		if value, err := hook(v); err != nil {
+			return nilValue, err
+		} else if value.IsValid() {
+			v = value
+		}
 	}
+	return v, nil
+}
+
+// marshalInterface returns the value from MarshalValue or the string from
+// MarshalText when v implements Marshaler or encoding.TextMarshaler, or
+// an invalid value otherwise. Values of time.Time are left to the hooks
+func marshalInterface(v reflect.Value) (reflect.Value, error) {
+	if v.Kind() == reflect.Interface || v.Type() == timeType || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		return nilValue, nil
+	}
+	// Make an addressable copy for pointer receivers
+	if !v.Type().Implements(marshalerType) && !v.Type().Implements(textMarshalerType) {
+		if v.Kind() == reflect.Ptr || !reflect.PtrTo(v.Type()).Implements(marshalerType) && !reflect.PtrTo(v.Type()).Implements(textMarshalerType) {
+			return nilValue, nil
+		}
+		ptr := reflect.New(v.Type())
+		ptr.Elem().Set(v)
+		v = ptr
+	}
+	switch value := v.Interface().(type) {
+	case Marshaler:
+		if result, err := value.MarshalValue(); err != nil {
+			return nilValue, err
+		} else {
+			return reflect.ValueOf(result), nil
+		}
+	case encoding.TextMarshaler:
+		if result, err := value.MarshalText(); err != nil {
+			return nilValue, err
+		} else {
+			return reflect.ValueOf(string(result)), nil
+		}
+	}
+	return nilValue, nil
+}
 
-	// Set index, type and value
-	result.Index = field.Index[0]
-	result.Type = field.Type
-	result.Value = value
+// needsEncoding returns true if a type is, or contains, a structure which
+// should be converted into a map or a value which should be converted by
+// its own marshaling methods
+func needsEncoding(t reflect.Type) bool {
+	// Types which implement Marshaler or encoding.TextMarshaler are converted
+	if t != timeType && t.Kind() != reflect.Interface {
+		if ptr := reflect.PtrTo(t); ptr.Implements(marshalerType) || ptr.Implements(textMarshalerType) {
+			return true
+		}
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		return t != timeType
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return needsEncoding(t.Elem())
+	case reflect.Interface:
+		return true
+	default:
+		return false
+	}
+}
 
-	// Set the field name
-	tags := strings.Split(field.Tag.Get(name), ",")
-	if tags[0] == "-" {
+// encodeQueryValue adds a value to url.Values, with slice elements added
+// as repeated keys. Elements of a value already converted by the hooks are
+// not converted again
+func (this *Encoder) encodeQueryValue(result url.Values, key string, v reflect.Value, convert bool) error {
+	if convert {
+		if value, err := this.marshalscalar(v); err != nil {
+			return err
+		} else if value.IsValid() && value.Type() != v.Type() {
+			v, convert = value, false
+		}
+	}
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	switch {
+	case !v.IsValid():
 		return nil
-	} else if tags[0] == "" {
-		result.Name = field.Name
-	} else {
-		result.Name = tags[0]
+	case (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() != reflect.Uint8:
+		for i := 0; i < v.Len(); i++ {
+			if err := this.encodeQueryValue(result, key, v.Index(i), convert); err != nil {
+				return err
+			}
+		}
+	default:
+		if value, err := formatQueryValue(v); err != nil {
+			return err
+		} else {
+			result.Add(key, value)
+		}
 	}
+	return nil
+}
 
-	// Set the tags
-	result.Tags = tags[1:]
+// formatQueryValue returns a scalar value as a string
+func formatQueryValue(v reflect.Value) (string, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+	switch {
+	case v.Type() == timeType:
+		return v.Interface().(time.Time).Format(time.RFC3339Nano), nil
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String(), nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes()), nil
+		}
+	}
+	return "", ErrUnsupported.With("cannot format ", v.Type())
+}
 
-	// Return the result
-	return &result
+// reflect returns the fields of a structure. Fields promoted through a nil
+// embedded pointer have a zero value when zero is true, or are otherwise
+// not returned
+func (this *Encoder) reflect(rv reflect.Value, zero bool) []*Field {
+	plan := structFields(rv.Type(), this.name)
+	result := make([]*Field, 0, len(plan.fields))
+	for _, field := range plan.fields {
+		value := fieldByIndex(rv, field.index, false)
+		if !value.IsValid() {
+			if !zero {
+				continue
+			}
+			value = reflect.Zero(field.Type)
+		}
+		// Copy the index path and tags, so that changes to a field do not
+		// change the cached plan
+		result = append(result, &Field{
+			Index:     field.index[0],
+			IndexPath: append(field.index[:0:0], field.index...),
+			Name:      field.name,
+			Type:      field.Type,
+			Value:     value,
+			Tags:      append(field.tags[:0:0], field.tags...),
+		})
+	}
+	return result
 }
@@ -1,6 +1,8 @@
 package marshaler_test
 
 import (
+	"errors"
+	"reflect"
 	"testing"
 	"time"
 
@@ -34,3 +36,368 @@ func Test_Encoder_002(t *testing.T) {
 		t.Log(field)
 	}
 }
+
+func Test_Encoder_003(t *testing.T) {
+	type ts struct {
+		Int     int               `yaml:"int"`
+		Float   float64           `yaml:"float"`
+		String  string            `yaml:"string"`
+		Time    time.Time         `yaml:"time"`
+		Ints    []int             `yaml:"ints"`
+		Map     map[string]string `yaml:"map"`
+		Ignored string            `yaml:"-"`
+	}
+	src := ts{
+		Int:    100,
+		Float:  3.1415,
+		String: "hello",
+		Time:   time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC),
+		Ints:   []int{1, 2, 3},
+		Map:    map[string]string{"a": "b"},
+	}
+	enc := marshaler.NewEncoder("yaml")
+	if m, err := enc.Encode(src); err != nil {
+		t.Fatal(err)
+	} else if _, exists := m["Ignored"]; exists {
+		t.Error("Unexpected ignored field", m)
+	} else {
+		var dest ts
+		if err := marshaler.NewDecoder("yaml").Decode(m, &dest); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(src, dest) {
+			t.Errorf("Unexpected round trip %+v != %+v", src, dest)
+		}
+	}
+}
+
+func Test_Encoder_004(t *testing.T) {
+	type inner struct {
+		A string `yaml:"a"`
+	}
+	type ts struct {
+		Struct  inner            `yaml:"struct"`
+		Ptr     *inner           `yaml:"ptr"`
+		Nil     *inner           `yaml:"nil"`
+		Structs []inner          `yaml:"structs"`
+		Map     map[string]inner `yaml:"map"`
+	}
+	src := ts{
+		Struct:  inner{"a"},
+		Ptr:     &inner{"b"},
+		Structs: []inner{{"c"}, {"d"}},
+		Map:     map[string]inner{"e": {"f"}},
+	}
+	expected := map[string]interface{}{
+		"struct":  map[string]interface{}{"a": "a"},
+		"ptr":     map[string]interface{}{"a": "b"},
+		"nil":     nil,
+		"structs": []interface{}{map[string]interface{}{"a": "c"}, map[string]interface{}{"a": "d"}},
+		"map":     map[string]interface{}{"e": map[string]interface{}{"a": "f"}},
+	}
+	if m, err := marshaler.NewEncoder("yaml").Encode(&src); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m, expected) {
+		t.Errorf("Unexpected encoding %+v != %+v", m, expected)
+	}
+}
+
+func Test_Encoder_005(t *testing.T) {
+	if _, err := marshaler.NewEncoder("yaml").Encode(100); err == nil {
+		t.Error("Expected error")
+	}
+}
+
+func Test_Encoder_006(t *testing.T) {
+	type ts struct {
+		Time     time.Time     `yaml:"timestamp"`
+		Duration time.Duration `yaml:"duration"`
+		String   string        `yaml:"string"`
+		Int      int           `yaml:"int"`
+		Uint8    uint8         `yaml:"uint8"`
+		Float    float64       `yaml:"float"`
+		Bool     bool          `yaml:"bool"`
+		Ints     []int         `yaml:"ints"`
+		Strings  []string      `yaml:"strings"`
+	}
+	src := ts{
+		Time:     time.Date(2016, time.January, 1, 12, 30, 0, 500, time.UTC),
+		Duration: 90 * time.Second,
+		String:   "hello, world",
+		Int:      -100,
+		Uint8:    255,
+		Float:    3.1415,
+		Bool:     true,
+		Ints:     []int{1, 2, 3},
+		Strings:  []string{"a", "b"},
+	}
+	q, err := marshaler.NewEncoder("yaml").EncodeQuery(src)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(q["ints"]) != 3 {
+		t.Error("Unexpected ints", q["ints"])
+	}
+	var dest ts
+	dec := marshaler.NewDecoder("yaml", marshaler.ConvertQueryValues, marshaler.ConvertTime, marshaler.ConvertDuration, marshaler.ConvertStringToNumber)
+	if err := dec.DecodeQuery(q, &dest); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(src, dest) {
+		t.Errorf("Unexpected round trip %+v != %+v", src, dest)
+	}
+}
+
+func Test_Encoder_007(t *testing.T) {
+	type ts struct {
+		Map map[string]string `yaml:"map"`
+	}
+	if _, err := marshaler.NewEncoder("yaml").EncodeQuery(ts{Map: map[string]string{}}); err == nil {
+		t.Error("Expected error")
+	}
+}
+
+func Test_Encoder_008(t *testing.T) {
+	type ts struct {
+		Time     time.Time         `yaml:"timestamp"`
+		Zero     time.Time         `yaml:"zero"`
+		Duration time.Duration     `yaml:"duration"`
+		Int8     int8              `yaml:"int8"`
+		Uint16   uint16            `yaml:"uint16"`
+		Ints     []int             `yaml:"ints"`
+		Map      map[string]string `yaml:"map"`
+	}
+	src := ts{
+		Time:     time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC),
+		Duration: 100 * time.Millisecond,
+		Int8:     -5,
+		Uint16:   500,
+		Ints:     []int{1, 2},
+		Map:      map[string]string{"a": "b"},
+	}
+	expected := map[string]interface{}{
+		"timestamp": "2016-01-01T00:00:00Z",
+		"zero":      "",
+		"duration":  "100ms",
+		"int8":      int(-5),
+		"uint16":    uint(500),
+		"ints":      []interface{}{1, 2},
+		"map":       map[string]interface{}{"a": "b"},
+	}
+	enc := marshaler.NewEncoder("yaml", marshaler.MarshalTime, marshaler.MarshalDuration, marshaler.MarshalIntUint, marshaler.MarshalMapInterface)
+	if m, err := enc.Encode(src); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m, expected) {
+		t.Errorf("Unexpected encoding %+v != %+v", m, expected)
+	}
+}
+
+func Test_Encoder_009(t *testing.T) {
+	type ts struct {
+		Int    int     `yaml:"int"`
+		Float  float64 `yaml:"float"`
+		Bool   bool    `yaml:"bool"`
+		String string  `yaml:"string"`
+		Uints  []uint  `yaml:"uints"`
+	}
+	src := ts{Int: 10, Float: 0.5, Bool: true, String: "s", Uints: []uint{1, 2}}
+	expected := map[string]interface{}{
+		"int":    "10",
+		"float":  "0.5",
+		"bool":   "true",
+		"string": "s",
+		"uints":  []interface{}{"1", "2"},
+	}
+	if m, err := marshaler.NewEncoder("yaml", marshaler.MarshalNumberToString).Encode(src); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m, expected) {
+		t.Errorf("Unexpected encoding %+v != %+v", m, expected)
+	}
+	enc := marshaler.NewEncoder("yaml", marshaler.MarshalNumberToString, marshaler.MarshalQueryValues)
+	if q, err := enc.EncodeQuery(src); err != nil {
+		t.Fatal(err)
+	} else if q.Get("float") != "0.5" || len(q["uints"]) != 2 {
+		t.Error("Unexpected query", q)
+	}
+}
+
+func Test_Encoder_010(t *testing.T) {
+	type ts struct {
+		Int      int               `yaml:"int,omitempty"`
+		String   string            `yaml:"string,omitempty"`
+		Ints     []int             `yaml:"ints,omitempty"`
+		Ptr      *int              `yaml:"ptr,omitempty"`
+		NilSlice []int             `yaml:"nilslice,omitnil"`
+		Empty    []int             `yaml:"empty,omitnil"`
+		NilMap   map[string]string `yaml:"nilmap,omitnil"`
+		Zero     int               `yaml:"zero,omitnil"`
+		Keep     int               `yaml:"keep"`
+	}
+	expected := map[string]interface{}{
+		"empty": []int{},
+		"zero":  0,
+		"keep":  0,
+	}
+	if m, err := marshaler.NewEncoder("yaml").Encode(ts{Empty: []int{}}); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m, expected) {
+		t.Errorf("Unexpected encoding %+v != %+v", m, expected)
+	}
+	if q, err := marshaler.NewEncoder("yaml").EncodeQuery(ts{String: "s"}); err != nil {
+		t.Fatal(err)
+	} else if len(q) != 3 || q.Get("string") != "s" || q.Get("zero") != "0" || q.Get("keep") != "0" {
+		t.Error("Unexpected query", q)
+	}
+}
+
+func Test_Encoder_011(t *testing.T) {
+	type ts struct {
+		A int `yaml:"a,omitempty,other"`
+		B int `yaml:"b"`
+	}
+	fields := marshaler.NewEncoder("yaml").Reflect(ts{})
+	if len(fields) != 2 {
+		t.Fatal("Unexpected fields", fields)
+	} else if !fields[0].HasTag("omitempty") || !fields[0].HasTag("other") || fields[0].HasTag("a") {
+		t.Error("Unexpected tags", fields[0].Tags)
+	} else if fields[1].HasTag("omitempty") {
+		t.Error("Unexpected tags", fields[1].Tags)
+	}
+}
+
+func Test_Encoder_012(t *testing.T) {
+	type Base struct {
+		ID int `yaml:"id"`
+	}
+	type Meta struct {
+		Version int `yaml:"version"`
+	}
+	type ts struct {
+		*Base
+		Meta `yaml:"meta"`
+		Name string `yaml:"name"`
+	}
+	enc := marshaler.NewEncoder("yaml")
+	if fields := enc.Reflect(ts{}); len(fields) != 3 {
+		t.Fatal("Unexpected fields", fields)
+	} else if fields[0].Name != "id" || fields[0].Index != 0 || fields[1].Name != "meta" || fields[2].Name != "name" {
+		t.Error("Unexpected fields", fields[0], fields[1], fields[2])
+	}
+	if m, err := enc.Encode(ts{Name: "a"}); err != nil {
+		t.Fatal(err)
+	} else if _, exists := m["id"]; exists {
+		t.Error("Unexpected field from nil embedded pointer", m)
+	}
+	expected := map[string]interface{}{
+		"id":   1,
+		"meta": map[string]interface{}{"version": 2},
+		"name": "a",
+	}
+	src := ts{&Base{1}, Meta{2}, "a"}
+	if m, err := enc.Encode(src); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(m, expected) {
+		t.Errorf("Unexpected encoding %+v != %+v", m, expected)
+	} else {
+		var dest ts
+		if err := marshaler.NewDecoder("yaml").Decode(m, &dest); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(src, dest) {
+			t.Errorf("Unexpected round trip %+v != %+v", src, dest)
+		}
+	}
+}
+
+func Test_Encoder_013(t *testing.T) {
+	type shape struct {
+		Origin point `yaml:"origin"`
+	}
+	result, err := marshaler.NewEncoder("yaml").Encode(shape{point{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, map[string]interface{}{"origin": map[string]interface{}{"x": 1, "y": 2}}) {
+		t.Errorf("Unexpected %v", result)
+	}
+	if result, err := marshaler.NewEncoder("yaml", marshaler.MarshalNumberToString).Encode(shape{point{1, 2}}); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(result, map[string]interface{}{"origin": map[string]interface{}{"x": "1", "y": "2"}}) {
+		t.Errorf("Unexpected %v", result)
+	}
+	if result, err := marshaler.NewEncoder("json").Encode(shape{point{1, 2}}); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(result, map[string]interface{}{"Origin": map[string]interface{}{"X": 1, "Y": 2}}) {
+		t.Errorf("Unexpected %v", result)
+	}
+}
+
+func Test_Encoder_014(t *testing.T) {
+	type in struct {
+		N int `yaml:"n"`
+	}
+	type ts struct {
+		A [2]in            `yaml:"a"`
+		D [2]time.Duration `yaml:"d"`
+		I [3]int           `yaml:"i"`
+	}
+	src := ts{A: [2]in{{1}, {2}}, D: [2]time.Duration{time.Second, time.Minute}, I: [3]int{1, 2, 3}}
+	m, err := marshaler.NewEncoder("yaml", marshaler.MarshalDuration).Encode(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dest ts
+	if err := marshaler.NewDecoder("yaml", marshaler.ConvertDuration).Decode(m, &dest); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(src, dest) {
+		t.Errorf("Unexpected round trip %+v != %+v", src, dest)
+	}
+	m["i"] = []interface{}{1, 2}
+	if err := marshaler.NewDecoder("yaml", marshaler.ConvertDuration).Decode(m, &dest); !errors.Is(err, marshaler.ErrOutOfRange) {
+		t.Error("Expected ErrOutOfRange, got", err)
+	}
+}
+
+func Test_Encoder_015(t *testing.T) {
+	type Base struct {
+		ID int `yaml:"id"`
+	}
+	type ts struct {
+		Skip string `yaml:"-"`
+		*Base
+		private int
+		Name    string `yaml:"name"`
+	}
+	src := ts{"skip", &Base{1}, 2, "a"}
+	fields := marshaler.NewEncoder("yaml").Reflect(src)
+	if len(fields) != 2 {
+		t.Fatal("Unexpected fields", fields)
+	}
+	for _, field := range fields {
+		if value := reflect.ValueOf(src).FieldByIndex(field.IndexPath); value.Interface() != field.Value.Interface() {
+			t.Error("Unexpected value", field.Name, value, field.Value)
+		}
+	}
+	if fields[0].Name != "id" || fields[0].Index != 1 || !reflect.DeepEqual(fields[0].IndexPath, []int{1, 0}) {
+		t.Error("Unexpected field", fields[0])
+	}
+}
+
+func Test_Encoder_016(t *testing.T) {
+	// Changing the fields returned does not change later encoding
+	type Base struct {
+		ID int `yaml:"id"`
+	}
+	type ts struct {
+		Base
+		Name string `yaml:"name,omitempty"`
+	}
+	enc := marshaler.NewEncoder("yaml")
+	fields := enc.Reflect(ts{})
+	fields[0].IndexPath[0] = 1
+	fields[1].Tags[0] = "x"
+	if fields := enc.Reflect(ts{}); !reflect.DeepEqual(fields[0].IndexPath, []int{0, 0}) || !reflect.DeepEqual(fields[1].Tags, []string{"omitempty"}) {
+		t.Error("Unexpected fields", fields[0], fields[1])
+	}
+	if result, err := enc.Encode(ts{}); err != nil {
+		t.Fatal(err)
+	} else if _, exists := result["name"]; exists {
+		t.Error("Unexpected", result)
+	}
+}
@@ -0,0 +1,116 @@
+package marshaler
+
+import (
+	"os"
+	"reflect"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// GLOBALS
+
+var (
+	// Characters in tag names which are replaced in environment variable names
+	envReplacer = strings.NewReplacer("-", "_", ".", "_")
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// PUBLIC METHODS
+
+// DecodeEnv decodes environment variables into dest, which should be a pointer
+// to a structure. See DecodeEnvFunc for how variables are named
+func (this *Decoder) DecodeEnv(prefix string, dest interface{}) error {
+	return this.DecodeEnvFunc(prefix, dest, os.LookupEnv)
+}
+
+// DecodeEnvFunc decodes variables returned by the lookup function into dest,
+// which should be a pointer to a structure. Variable names are the prefix and
+// tag names in upper case separated by underscores, so that a field with tag
+// name "host" within a nested structure with tag name "db" is read from
+// PREFIX_DB_HOST. Values are strings which are converted by the conversion
+// functions, and comma-separated values are decoded into slices
+func (this *Decoder) DecodeEnvFunc(prefix string, dest interface{}, lookup func(string) (string, bool)) error {
+	d, err := structDest(dest, "DecodeEnv")
+	if err != nil {
+		return err
+	} else if lookup == nil {
+		return ErrBadParameter.With("DecodeEnv: nil lookup function")
+	}
+
+	// Read the variables into nested maps, and decode them
+	u := &unmarshaler{name: this.name, fn: this.unmarshalscalar, merge: this.merge, max: this.maxErrors}
+	src := this.envValues(d.Type(), strings.TrimSuffix(prefix, "_"), lookup, map[reflect.Type]bool{})
+	var result Errors
+	if err := u.unmarshalStruct(reflect.ValueOf(src), d, ""); err != nil {
+		result = u.appendError(result, err)
+	}
+	return result.errorOrNil()
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+// envValues returns the variables for the fields of a structure, where nested
+// structures are returned as nested maps if any of their variables are set
+func (this *Decoder) envValues(t reflect.Type, prefix string, lookup func(string) (string, bool), visited map[reflect.Type]bool) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	// Guard against recursive structures
+	if visited[t] {
+		return result
+	}
+	visited[t] = true
+	defer delete(visited, t)
+
+	for _, field := range structFields(t, this.name).fields {
+		name := envName(prefix, field.name)
+		ft := field.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if isNestedStruct(ft) {
+			if values := this.envValues(ft, name, lookup, visited); len(values) > 0 {
+				result[field.name] = values
+			}
+		} else if value, exists := lookup(name); !exists {
+			continue
+		} else if ft.Kind() == reflect.Slice && !reflect.PtrTo(ft).Implements(textUnmarshalerType) {
+			result[field.name] = splitList(value)
+		} else {
+			result[field.name] = value
+		}
+	}
+	return result
+}
+
+// envName returns the environment variable name for a tag name
+func envName(prefix, name string) string {
+	name = strings.ToUpper(envReplacer.Replace(name))
+	if prefix == "" {
+		return name
+	}
+	return prefix + "_" + name
+}
+
+// isNestedStruct returns true for structures which are decoded field by
+// field, rather than from a single value
+func isNestedStruct(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct || t == timeType {
+		return false
+	}
+	c := unmarshalConverter(t)
+	return c == convertNone || c == convertMap
+}
+
+// splitList returns the trimmed elements of a comma-separated list, or an
+// empty list for an empty string
+func splitList(value string) []string {
+	if strings.TrimSpace(value) == "" {
+		return []string{}
+	}
+	result := strings.Split(value, ",")
+	for i := range result {
+		result[i] = strings.TrimSpace(result[i])
+	}
+	return result
+}
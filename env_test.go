@@ -0,0 +1,104 @@
+package marshaler_test
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/djthorpe/go-marshaler"
+)
+
+type envDatabase struct {
+	Host    string        `env:"host,required"`
+	Port    uint16        `env:"port,default=5432"`
+	Timeout time.Duration `env:"timeout"`
+}
+
+type envConfig struct {
+	Name    string       `env:"name"`
+	Debug   bool         `env:"debug"`
+	Started time.Time    `env:"started"`
+	Hosts   []string     `env:"hosts"`
+	Ports   []int        `env:"ports"`
+	IP      net.IP       `env:"listen-ip"`
+	DB      envDatabase  `env:"db"`
+	Cache   *envDatabase `env:"cache"`
+}
+
+func lookup(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, exists := env[name]
+		return value, exists
+	}
+}
+
+func Test_Env_001(t *testing.T) {
+	env := map[string]string{
+		"APP_NAME":       "server",
+		"APP_DEBUG":      "true",
+		"APP_STARTED":    "2020-01-02T03:04:05Z",
+		"APP_HOSTS":      "a, b,c",
+		"APP_PORTS":      "80,443",
+		"APP_LISTEN_IP":  "10.0.0.1",
+		"APP_DB_HOST":    "localhost",
+		"APP_DB_TIMEOUT": "5s",
+		"NAME":           "other",
+	}
+	var dest envConfig
+	if err := marshaler.NewDecoder("env", marshaler.ConvertStringToNumber, marshaler.ConvertDuration, marshaler.ConvertTime).DecodeEnvFunc("APP", &dest, lookup(env)); err != nil {
+		t.Fatal(err)
+	}
+	expected := envConfig{
+		Name:    "server",
+		Debug:   true,
+		Started: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Hosts:   []string{"a", "b", "c"},
+		Ports:   []int{80, 443},
+		IP:      net.ParseIP("10.0.0.1"),
+		DB:      envDatabase{"localhost", 5432, 5 * time.Second},
+	}
+	if !reflect.DeepEqual(dest, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, dest)
+	}
+}
+
+func Test_Env_002(t *testing.T) {
+	// Nested pointers are allocated when any variable is set, and errors
+	// include the path
+	env := map[string]string{
+		"CACHE_PORT": "x",
+		"HOSTS":      "",
+	}
+	var dest envConfig
+	err := marshaler.NewDecoder("env", marshaler.ConvertStringToNumber, marshaler.ConvertDuration, marshaler.ConvertTime).DecodeEnvFunc("", &dest, lookup(env))
+	var errs marshaler.Errors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("Unexpected %v", err)
+	}
+	var fieldErr *marshaler.FieldError
+	if !errors.As(errs[0], &fieldErr) || fieldErr.Path != "cache.host" || !errors.Is(errs[0], marshaler.ErrRequired) {
+		t.Errorf("Unexpected %v", errs[0])
+	}
+	if !errors.As(errs[1], &fieldErr) || fieldErr.Path != "cache.port" || !errors.Is(errs[1], marshaler.ErrTypeMismatch) {
+		t.Errorf("Unexpected %v", errs[1])
+	}
+	if dest.Cache == nil || dest.Hosts == nil || len(dest.Hosts) != 0 {
+		t.Errorf("Unexpected %+v", dest)
+	}
+}
+
+func Test_Env_003(t *testing.T) {
+	t.Setenv("MARSHALER_TEST_NAME", "test")
+	t.Setenv("MARSHALER_TEST_DB_HOST", "db")
+	var dest envConfig
+	if err := marshaler.NewDecoder("env", marshaler.ConvertStringToNumber, marshaler.ConvertDuration, marshaler.ConvertTime).DecodeEnv("MARSHALER_TEST_", &dest); err != nil {
+		t.Fatal(err)
+	} else if dest.Name != "test" || dest.DB.Host != "db" {
+		t.Errorf("Unexpected %+v", dest)
+	}
+	if err := marshaler.NewDecoder("env", marshaler.ConvertStringToNumber, marshaler.ConvertDuration, marshaler.ConvertTime).DecodeEnv("", dest); !errors.Is(err, marshaler.ErrBadParameter) {
+		t.Errorf("Unexpected %v", err)
+	}
+}
@@ -1,18 +1,55 @@
 package marshaler
 
-import "fmt"
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
 
 ///////////////////////////////////////////////////////////////////////////////
 // TYPES
 
 type Error int
 
+// Errors is a list of errors returned when decoding, which can be walked
+// with errors.Is and errors.As or ranged over
+type Errors []error
+
+// FieldError is returned when a value cannot be decoded, and can be matched
+// with errors.As
+type FieldError struct {
+	// The location of the value within the source, for example servers[2].port
+	Path string
+
+	// The source key, for example port
+	Key string
+
+	// The type of the source value, or nil if the value is missing
+	Src reflect.Type
+
+	// The type of the destination
+	Dest reflect.Type
+
+	// The wrapped cause of the error
+	Err error
+
+	// The line number in the source for sources which have lines, such as
+	// CSV files, or zero
+	Line int
+}
+
 ///////////////////////////////////////////////////////////////////////////////
 // CONSTANTS
 
 const (
 	ErrSuccess Error = iota
 	ErrBadParameter
+	ErrTypeMismatch
+	ErrOutOfRange
+	ErrRequired
+	ErrUnknownField
+	ErrParse
+	ErrUnsupported
 )
 
 ///////////////////////////////////////////////////////////////////////////////
@@ -24,6 +61,18 @@ func (e Error) Error() string {
 		return "ErrSuccess"
 	case ErrBadParameter:
 		return "ErrBadParameter"
+	case ErrTypeMismatch:
+		return "ErrTypeMismatch"
+	case ErrOutOfRange:
+		return "ErrOutOfRange"
+	case ErrRequired:
+		return "ErrRequired"
+	case ErrUnknownField:
+		return "ErrUnknownField"
+	case ErrParse:
+		return "ErrParse"
+	case ErrUnsupported:
+		return "ErrUnsupported"
 	default:
 		return "[?? Invalid Error value]"
 	}
@@ -32,3 +81,59 @@ func (e Error) Error() string {
 func (e Error) With(args ...interface{}) error {
 	return fmt.Errorf("%s: %w", fmt.Sprint(args...), e)
 }
+
+// Wrap returns an error which wraps both the error and the cause
+func (e Error) Wrap(err error) error {
+	return fmt.Errorf("%w: %w", err, e)
+}
+
+func (e *FieldError) Error() string {
+	var str strings.Builder
+	if e.Line > 0 {
+		fmt.Fprintf(&str, "line %d: ", e.Line)
+	}
+	if e.Path != "" {
+		str.WriteString(e.Path)
+		str.WriteString(": ")
+	}
+	if e.Src != nil && e.Dest != nil {
+		fmt.Fprintf(&str, "cannot decode %v into %v: ", e.Src, e.Dest)
+	}
+	str.WriteString(e.Err.Error())
+	return str.String()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+func (e Errors) Error() string {
+	var str strings.Builder
+	for i, err := range e {
+		if i > 0 {
+			str.WriteString("\n")
+		}
+		str.WriteString(err.Error())
+	}
+	return str.String()
+}
+
+func (e Errors) Unwrap() []error {
+	return e
+}
+
+// Append adds an error to the list, flattening any nested list of errors
+func (e Errors) Append(err error) Errors {
+	if errs, ok := err.(Errors); ok {
+		return append(e, errs...)
+	}
+	return append(e, err)
+}
+
+// errorOrNil returns nil if the list is empty
+func (e Errors) errorOrNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,271 @@
+package marshaler
+
+import (
+	"encoding"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// TYPES
+
+// structField is a field within a structure, which may be promoted from an
+// embedded structure
+type structField struct {
+	reflect.StructField
+
+	// The path of field indexes from the outer structure
+	index []int
+
+	// The name of the field, from the tag or the field name
+	name string
+
+	// The tag options after the name
+	tags []string
+
+	// The depth of embedding, zero for fields of the outer structure
+	depth int
+
+	// The name of the field as a value, for map lookups
+	key reflect.Value
+
+	// The "required" and "default=..." tag options
+	required   bool
+	def        string
+	hasDefault bool
+}
+
+// structPlan is the compiled list of fields for a structure and tag name,
+// which is cached and reused
+type structPlan struct {
+	fields []structField
+	names  map[string]bool
+}
+
+// planKey is the key for cached plans
+type planKey struct {
+	t    reflect.Type
+	name string
+}
+
+// converter is the method a type implements for decoding itself
+type converter int
+
+///////////////////////////////////////////////////////////////////////////////
+// CONSTANTS
+
+const (
+	convertNone converter = iota
+	convertUnmarshaler
+	convertMap
+	convertText
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// GLOBALS
+
+var (
+	// Compiled plans for each structure type and tag name
+	plans sync.Map
+
+	// Converter for each destination type
+	converters sync.Map
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+// structFields returns the compiled fields of a structure identified by tag
+// name. The plan is compiled once for each structure type and tag name
+func structFields(t reflect.Type, tagName string) *structPlan {
+	key := planKey{t, tagName}
+	if plan, exists := plans.Load(key); exists {
+		return plan.(*structPlan)
+	}
+	plan, _ := plans.LoadOrStore(key, compileStructFields(t, tagName))
+	return plan.(*structPlan)
+}
+
+// compileStructFields returns the fields of a structure identified by tag name.
+// Embedded structures (and pointers to structures) without a tag name have
+// their fields squashed into the outer structure, and embedded structures
+// with a tag name are returned as a field with that name. When more than one
+// field has the same name, the least embedded field is returned, or none if
+// they are embedded at the same depth
+func compileStructFields(t reflect.Type, tagName string) *structPlan {
+	var fields []structField
+	appendStructFields(&fields, t, tagName, nil, map[reflect.Type]bool{})
+
+	// Determine the dominant field for each name
+	dominant := make(map[string]int, len(fields))
+	for i, field := range fields {
+		if j, exists := dominant[field.name]; !exists || field.depth < fields[j].depth {
+			dominant[field.name] = i
+		} else if field.depth == fields[j].depth {
+			dominant[field.name] = -1
+		}
+	}
+
+	// Return dominant fields in order, with their options
+	plan := &structPlan{
+		fields: make([]structField, 0, len(fields)),
+		names:  make(map[string]bool, len(fields)),
+	}
+	for i, field := range fields {
+		if dominant[field.name] != i {
+			continue
+		}
+		field.key = reflect.ValueOf(field.name)
+		field.required = field.hasTag("required")
+		field.def, field.hasDefault = field.tagValue("default")
+		plan.fields = append(plan.fields, field)
+		plan.names[field.name] = true
+	}
+	return plan
+}
+
+func appendStructFields(fields *[]structField, t reflect.Type, tagName string, index []int, visited map[reflect.Type]bool) {
+	// Guard against recursive embedding
+	if visited[t] {
+		return
+	}
+	visited[t] = true
+	defer delete(visited, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		exported := field.Name != "" && !unicode.IsLower(rune(field.Name[0]))
+		tags := splitTag(field.Tag.Get(tagName))
+		if tags[0] == "-" {
+			continue
+		}
+
+		// Set the index path from the outer structure
+		path := make([]int, len(index)+1)
+		copy(path, index)
+		path[len(index)] = i
+
+		// Squash embedded structures without a tag name. Fields within private
+		// embedded pointers cannot be allocated and are ignored
+		if field.Anonymous && tags[0] == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+				if !exported {
+					continue
+				}
+			}
+			if ft.Kind() == reflect.Struct {
+				appendStructFields(fields, ft, tagName, path, visited)
+				continue
+			}
+		}
+
+		// Private fields not supported
+		if !exported {
+			continue
+		}
+
+		// Set the field name
+		name := tags[0]
+		if name == "" {
+			name = field.Name
+		}
+
+		*fields = append(*fields, structField{StructField: field, index: path, name: name, tags: tags[1:], depth: len(index)})
+	}
+}
+
+// splitTag returns the name and options of a tag, where the default= option
+// is the rest of the tag, so that a default value can contain commas
+func splitTag(tag string) []string {
+	tags := strings.Split(tag, ",")
+	for i := 1; i < len(tags); i++ {
+		if strings.HasPrefix(tags[i], "default=") {
+			return append(tags[:i], strings.Join(tags[i:], ","))
+		}
+	}
+	return tags
+}
+
+// hasTag returns true if the field has a tag option
+func (field structField) hasTag(tag string) bool {
+	for _, t := range field.tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// tagValue returns the value of a tag option in the form key=value
+func (field structField) tagValue(key string) (string, bool) {
+	for _, t := range field.tags {
+		if strings.HasPrefix(t, key+"=") {
+			return t[len(key)+1:], true
+		}
+	}
+	return "", false
+}
+
+// fieldByIndex returns the field of a structure, allocating any nil
+// embedded pointers on the way when alloc is true. It returns an invalid
+// value if a nil pointer is encountered and alloc is false
+func fieldByIndex(v reflect.Value, index []int, alloc bool) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// unmarshalConverter returns the method a destination type implements for
+// decoding itself, which is cached for each type. Values of time.Time are
+// left to the scalar conversion function
+func unmarshalConverter(t reflect.Type) converter {
+	if c, exists := converters.Load(t); exists {
+		return c.(converter)
+	}
+	c := convertNone
+	switch ptr := reflect.PtrTo(t); {
+	case t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface:
+		break
+	case ptr.Implements(unmarshalerType):
+		c = convertUnmarshaler
+	case ptr.Implements(mapDecoderType):
+		c = convertMap
+	case t != timeType && ptr.Implements(textUnmarshalerType):
+		c = convertText
+	}
+	converters.Store(t, c)
+	return c
+}
+
+// textUnmarshaler returns the encoding.TextUnmarshaler for an addressable value
+func textUnmarshaler(v reflect.Value) encoding.TextUnmarshaler {
+	return v.Addr().Interface().(encoding.TextUnmarshaler)
+}
+
+// hasMapMethods returns false if a value has a MarshalerTag method which
+// returns a different tag name, or a MarshalerType method which returns a
+// different type than t, for methods generated for a specific tag name and
+// type which are promoted to structures embedding the type
+func hasMapMethods(v interface{}, t reflect.Type, name string) bool {
+	if tagged, ok := v.(interface{ MarshalerTag() string }); ok && tagged.MarshalerTag() != name {
+		return false
+	}
+	if typed, ok := v.(interface{ MarshalerType() interface{} }); ok && reflect.TypeOf(typed.MarshalerType()) != reflect.PtrTo(t) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package marshaler
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type benchRow struct {
+	ID       int           `db:"id"`
+	Name     string        `db:"name"`
+	Email    string        `db:"email"`
+	Score    float64       `db:"score"`
+	Active   bool          `db:"active"`
+	Created  time.Time     `db:"created"`
+	Timeout  time.Duration `db:"timeout"`
+	Tags     []string      `db:"tags"`
+	Port     uint16        `db:"port,default=80"`
+	Optional *string       `db:"optional"`
+}
+
+func benchRows(n int) []interface{} {
+	rows := make([]interface{}, n)
+	for i := range rows {
+		rows[i] = map[string]interface{}{
+			"id":      i,
+			"name":    fmt.Sprint("name", i),
+			"email":   fmt.Sprint("user", i, "@example.com"),
+			"score":   float64(i) / 10,
+			"active":  i%2 == 0,
+			"created": "2016-01-01T00:00:00Z",
+			"timeout": "10s",
+			"tags":    []interface{}{"a", "b"},
+		}
+	}
+	return rows
+}
+
+// clearCache removes every entry from a cache
+func clearCache(m *sync.Map) {
+	m.Range(func(key, _ interface{}) bool {
+		m.Delete(key)
+		return true
+	})
+}
+
+func Test_Fields_001(t *testing.T) {
+	clearCache(&plans)
+	a := structFields(reflect.TypeOf(benchRow{}), "db")
+	b := structFields(reflect.TypeOf(benchRow{}), "db")
+	c := structFields(reflect.TypeOf(benchRow{}), "json")
+	if a != b {
+		t.Error("Expected cached plan")
+	} else if a == c {
+		t.Error("Expected plan for each tag name")
+	} else if len(a.fields) != 10 || a.fields[8].name != "port" || !a.fields[8].hasDefault || a.fields[8].def != "80" {
+		t.Error("Unexpected plan", a.fields)
+	} else if c.fields[0].name != "ID" {
+		t.Error("Unexpected plan", c.fields)
+	}
+}
+
+func Test_Fields_002(t *testing.T) {
+	// Compile plans concurrently
+	clearCache(&plans)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var dest []benchRow
+			if err := NewDecoder("db", ConvertTime, ConvertDuration, ConvertIntUint, ConvertStringToNumber).Decode(benchRows(10), &dest); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func Benchmark_Decode_Cached(b *testing.B) {
+	benchmarkDecode(b, false)
+}
+
+func Benchmark_Decode_Uncached(b *testing.B) {
+	benchmarkDecode(b, true)
+}
+
+func benchmarkDecode(b *testing.B, clear bool) {
+	src := benchRows(1000)
+	dec := NewDecoder("db", ConvertTime, ConvertDuration, ConvertIntUint, ConvertStringToNumber)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var dest []benchRow
+		for _, row := range src {
+			// Compile the plan for every row, as before plans were cached
+			if clear {
+				clearCache(&plans)
+				clearCache(&converters)
+			}
+			var elem benchRow
+			if err := dec.Decode(row, &elem); err != nil {
+				b.Fatal(err)
+			}
+			dest = append(dest, elem)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package marshaler
+
+import (
+	"encoding"
+	"flag"
+	"reflect"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// TYPES
+
+// flagValue collects the values of a flag, which are decoded into a field
+// after the flags are parsed
+type flagValue struct {
+	// The path of tag names to the field
+	path []string
+
+	// The field type
+	t reflect.Type
+
+	// The default value, and values set from the command line
+	def    string
+	values []string
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PUBLIC METHODS
+
+// RegisterFlags registers a flag on the flag set for each field of dest,
+// which should be a pointer to a structure. Flag names are the tag names,
+// where fields of nested structures have dotted names such as db.host. The
+// default is the "default=" tag option or the current value of the field,
+// and the usage is the "help" struct tag. Slices are set by repeating the
+// flag. Call DecodeFlags after the flags are parsed
+func (this *Decoder) RegisterFlags(fs *flag.FlagSet, dest interface{}) error {
+	d, err := structDest(dest, "RegisterFlags")
+	if err != nil {
+		return err
+	} else if fs == nil {
+		return ErrBadParameter.With("RegisterFlags: nil flag set")
+	}
+	return this.registerFlags(fs, d, nil, map[reflect.Type]bool{})
+}
+
+// DecodeFlags decodes the flags registered by RegisterFlags which were set on
+// the command line into dest, after the flags are parsed. Values are strings
+// which are converted by the conversion functions
+func (this *Decoder) DecodeFlags(fs *flag.FlagSet, dest interface{}) error {
+	d, err := structDest(dest, "DecodeFlags")
+	if err != nil {
+		return err
+	} else if fs == nil {
+		return ErrBadParameter.With("DecodeFlags: nil flag set")
+	} else if !fs.Parsed() {
+		return ErrBadParameter.With("DecodeFlags: flags have not been parsed")
+	}
+
+	// Collect values of flags which were set into nested maps
+	src := make(map[string]interface{})
+	fs.Visit(func(f *flag.Flag) {
+		value, ok := f.Value.(*flagValue)
+		if !ok {
+			return
+		}
+		m := src
+		for _, name := range value.path[:len(value.path)-1] {
+			if _, exists := m[name]; !exists {
+				m[name] = make(map[string]interface{})
+			}
+			m = m[name].(map[string]interface{})
+		}
+		m[value.path[len(value.path)-1]] = value.value()
+	})
+
+	// Decode the values
+	u := &unmarshaler{name: this.name, fn: this.unmarshalscalar, merge: this.merge, max: this.maxErrors}
+	var result Errors
+	if err := u.unmarshalStruct(reflect.ValueOf(src), d, ""); err != nil {
+		result = u.appendError(result, err)
+	}
+	return result.errorOrNil()
+}
+
+// String returns the values of the flag separated by commas, or the default
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	} else if len(f.values) > 0 {
+		return strings.Join(f.values, ",")
+	}
+	return f.def
+}
+
+// Set sets the value of the flag, or appends a value for slices
+func (f *flagValue) Set(value string) error {
+	if f.t.Kind() != reflect.Slice || reflect.PtrTo(f.t).Implements(textUnmarshalerType) {
+		f.values = f.values[:0]
+	}
+	f.values = append(f.values, value)
+	return nil
+}
+
+// IsBoolFlag returns true for flags which do not need a value
+func (f *flagValue) IsBoolFlag() bool {
+	return f.t.Kind() == reflect.Bool
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+func (this *Decoder) registerFlags(fs *flag.FlagSet, v reflect.Value, path []string, visited map[reflect.Type]bool) error {
+	// Guard against recursive structures
+	if visited[v.Type()] {
+		return nil
+	}
+	visited[v.Type()] = true
+	defer delete(visited, v.Type())
+
+	for _, field := range structFields(v.Type(), this.name).fields {
+		path := append(append([]string{}, path...), field.name)
+
+		// Get the current value and type, which is a zero value when promoted
+		// through a nil pointer
+		value := fieldByIndex(v, field.index, false)
+		if !value.IsValid() {
+			value = reflect.Zero(field.Type)
+		}
+		t := field.Type
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+			if value.IsNil() {
+				value = reflect.Zero(t)
+			} else {
+				value = value.Elem()
+			}
+		}
+
+		// Register fields of nested structures
+		if isNestedStruct(t) {
+			if err := this.registerFlags(fs, value, path, visited); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Skip types which cannot be set from a string
+		switch t.Kind() {
+		case reflect.Map, reflect.Func, reflect.Chan, reflect.Interface, reflect.UnsafePointer:
+			continue
+		}
+
+		// Register the flag
+		name := strings.Join(path, ".")
+		if fs.Lookup(name) != nil {
+			return ErrBadParameter.With("RegisterFlags: flag redefined: ", name)
+		}
+		def, exists := field.tagValue("default")
+		if !exists {
+			def = flagDefault(value)
+		}
+		fs.Var(&flagValue{path: path, t: t, def: def}, name, field.Tag.Get("help"))
+	}
+
+	// Return success
+	return nil
+}
+
+// value returns the values of the flag, as a string or a slice of strings
+func (f *flagValue) value() interface{} {
+	if v, err := ConvertQueryValues(reflect.ValueOf(f.values), f.t); err == nil && v.IsValid() {
+		return v.Interface()
+	}
+	return f.values
+}
+
+// flagDefault returns the current value of a field as a string, or an empty
+// string for zero values
+func flagDefault(v reflect.Value) string {
+	if v.IsZero() {
+		return ""
+	}
+	if marshaler, ok := v.Interface().(encoding.TextMarshaler); ok && v.Type() != timeType {
+		if text, err := marshaler.MarshalText(); err == nil {
+			return string(text)
+		}
+		return ""
+	}
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+		values := make([]string, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			value, err := formatQueryValue(v.Index(i))
+			if err != nil {
+				return ""
+			}
+			values = append(values, value)
+		}
+		return strings.Join(values, ",")
+	}
+	value, _ := formatQueryValue(v)
+	return value
+}
@@ -0,0 +1,117 @@
+package marshaler_test
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djthorpe/go-marshaler"
+)
+
+type flagDatabase struct {
+	Host    string        `flag:"host" help:"Database host"`
+	Timeout time.Duration `flag:"timeout,default=5s" help:"Database timeout"`
+}
+
+type flagOptions struct {
+	Verbose bool          `flag:"verbose" help:"Verbose output"`
+	Port    uint16        `flag:"port" help:"Port"`
+	Since   time.Time     `flag:"since"`
+	Tags    []string      `flag:"tag" help:"Tags"`
+	IP      net.IP        `flag:"ip"`
+	DB      flagDatabase  `flag:"db"`
+	Cache   *flagDatabase `flag:"cache"`
+	Ignored string        `flag:"-"`
+}
+
+func Test_Flags_001(t *testing.T) {
+	dest := flagOptions{Port: 8080, Tags: []string{"x"}}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	dec := marshaler.NewDecoder("flag", marshaler.ConvertStringToNumber, marshaler.ConvertDuration, marshaler.ConvertTime)
+	if err := dec.RegisterFlags(fs, &dest); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check the flags
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		names = append(names, f.Name)
+	})
+	if !reflect.DeepEqual(names, []string{"cache.host", "cache.timeout", "db.host", "db.timeout", "ip", "port", "since", "tag", "verbose"}) {
+		t.Errorf("Unexpected %v", names)
+	}
+	if f := fs.Lookup("port"); f.DefValue != "8080" || f.Usage != "Port" {
+		t.Errorf("Unexpected %+v", f)
+	}
+	if f := fs.Lookup("db.timeout"); f.DefValue != "5s" || f.Usage != "Database timeout" {
+		t.Errorf("Unexpected %+v", f)
+	}
+	if f := fs.Lookup("tag"); f.DefValue != "x" {
+		t.Errorf("Unexpected %+v", f)
+	}
+
+	// Parse and decode the flags
+	args := []string{"-verbose", "-since", "2020-01-02T03:04:05Z", "-tag", "a", "-tag", "b", "-ip", "10.0.0.1", "-db.host", "localhost", "-cache.timeout", "1m"}
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.DecodeFlags(fs, &dest); err != nil {
+		t.Fatal(err)
+	}
+	expected := flagOptions{
+		Verbose: true,
+		Port:    8080,
+		Since:   time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Tags:    []string{"a", "b"},
+		IP:      net.ParseIP("10.0.0.1"),
+		DB:      flagDatabase{"localhost", 5 * time.Second},
+		Cache:   &flagDatabase{"", time.Minute},
+	}
+	if !reflect.DeepEqual(dest, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, dest)
+	}
+}
+
+func Test_Flags_002(t *testing.T) {
+	// Usage includes help and defaults
+	var dest flagOptions
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	var out bytes.Buffer
+	fs.SetOutput(&out)
+	if err := marshaler.NewDecoder("flag", marshaler.ConvertStringToNumber, marshaler.ConvertDuration, marshaler.ConvertTime).RegisterFlags(fs, &dest); err != nil {
+		t.Fatal(err)
+	}
+	fs.PrintDefaults()
+	if usage := out.String(); !strings.Contains(usage, "Database timeout (default 5s)") || !strings.Contains(usage, "-verbose\n") {
+		t.Errorf("Unexpected %q", usage)
+	}
+
+	// Errors include the flag name
+	if err := fs.Parse([]string{"-port", "x"}); err != nil {
+		t.Fatal(err)
+	}
+	err := marshaler.NewDecoder("flag", marshaler.ConvertStringToNumber, marshaler.ConvertDuration, marshaler.ConvertTime).DecodeFlags(fs, &dest)
+	var fieldErr *marshaler.FieldError
+	if !errors.As(err, &fieldErr) || fieldErr.Path != "port" {
+		t.Errorf("Unexpected %v", err)
+	}
+}
+
+func Test_Flags_003(t *testing.T) {
+	var dest flagOptions
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	if err := marshaler.NewDecoder("flag", marshaler.ConvertStringToNumber, marshaler.ConvertDuration, marshaler.ConvertTime).DecodeFlags(fs, &dest); !errors.Is(err, marshaler.ErrBadParameter) {
+		t.Errorf("Unexpected %v", err)
+	}
+	if err := marshaler.NewDecoder("flag", marshaler.ConvertStringToNumber, marshaler.ConvertDuration, marshaler.ConvertTime).RegisterFlags(fs, &dest); err != nil {
+		t.Fatal(err)
+	}
+	if err := marshaler.NewDecoder("flag", marshaler.ConvertStringToNumber, marshaler.ConvertDuration, marshaler.ConvertTime).RegisterFlags(fs, &dest); !errors.Is(err, marshaler.ErrBadParameter) {
+		t.Errorf("Unexpected %v", err)
+	}
+}
@@ -1,5 +1,3 @@
 module github.com/djthorpe/go-marshaler
 
-go 1.13
-
-require github.com/hashicorp/go-multierror v1.1.1
+go 1.22
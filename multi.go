@@ -0,0 +1,108 @@
+package marshaler
+
+import (
+	"reflect"
+	"sort"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// PUBLIC METHODS
+
+// DecodeMulti decodes a multi-valued source, for example http.Header,
+// textproto.MIMEHeader or multipart.Form.Value, into dest, which should be
+// a pointer to a structure. Each value is converted with ConvertQueryValues
+// and then the conversion functions, so single values are decoded into
+// scalars and repeated values into slices. When canonical is not nil, source
+// keys and field names are matched after converting them with the function,
+// for example http.CanonicalHeaderKey or strings.ToLower
+func (this *Decoder) DecodeMulti(src map[string][]string, dest interface{}, canonical func(string) string) error {
+	d, err := structDest(dest, "DecodeMulti")
+	if err != nil {
+		return err
+	}
+	if canonical == nil {
+		canonical = func(key string) string {
+			return key
+		}
+	}
+
+	// Index the source by canonical key, in key order
+	keys := make([]string, 0, len(src))
+	for key := range src {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make(map[string][]string, len(src))
+	for _, key := range keys {
+		values[canonical(key)] = append(values[canonical(key)], src[key]...)
+	}
+
+	// Find source keys which do not match any field in strict mode
+	var unknown []string
+	if this.strict {
+		names := make(map[string]bool)
+		for _, field := range structFields(d.Type(), this.name).fields {
+			names[canonical(field.name)] = true
+		}
+		for _, key := range keys {
+			if !names[canonical(key)] {
+				unknown = append(unknown, key)
+			}
+		}
+	}
+
+	// Decode the values
+	return this.decodeValues(d, func(field structField) ([]string, error) {
+		return values[canonical(field.name)], nil
+	}, unknown)
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+// decodeValues decodes the values returned by lookup for each field of a
+// structure, converted with ConvertQueryValues, and returns an error for
+// each unknown source key
+func (this *Decoder) decodeValues(d reflect.Value, lookup func(structField) ([]string, error), unknown []string) error {
+	u := &unmarshaler{name: this.name, fn: this.unmarshalscalar, merge: this.merge, max: this.maxErrors}
+	src := make(map[string]interface{})
+
+	// Collect the source values for each field
+	for _, field := range structFields(d.Type(), this.name).fields {
+		values, err := lookup(field)
+		if err != nil {
+			return err
+		} else if len(values) == 0 {
+			continue
+		}
+		// Values which cannot be converted are decoded as they are, and
+		// return an error
+		if v, err := ConvertQueryValues(reflect.ValueOf(values), field.Type); err != nil {
+			src[field.name] = values
+		} else {
+			src[field.name] = v.Interface()
+		}
+	}
+
+	// Decode the values
+	var result Errors
+	if err := u.unmarshalStruct(reflect.ValueOf(src), d, ""); err != nil {
+		result = u.appendError(result, err)
+	}
+	for _, key := range unknown {
+		if u.done() {
+			break
+		}
+		result = u.appendError(result, newFieldError(key, nil, nil, ErrUnknownField))
+	}
+	return result.errorOrNil()
+}
+
+// structDest returns the structure from a pointer to a structure
+func structDest(dest interface{}, method string) (reflect.Value, error) {
+	d := reflect.ValueOf(dest)
+	if d.Kind() != reflect.Ptr || d.IsNil() || d.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, ErrBadParameter.With(method, ": destination should be ptr to struct")
+	}
+	return d.Elem(), nil
+}
@@ -0,0 +1,77 @@
+package marshaler_test
+
+import (
+	"errors"
+	"net/http"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djthorpe/go-marshaler"
+)
+
+type headers struct {
+	RequestId string        `header:"x-request-id,required"`
+	Accept    []string      `header:"accept"`
+	Length    int           `header:"content-length"`
+	Timeout   time.Duration `header:"x-timeout,default=30s"`
+}
+
+func Test_Multi_001(t *testing.T) {
+	// Canonical header keys
+	src := http.Header{}
+	src.Set("X-Request-Id", "abc")
+	src.Add("Accept", "text/plain")
+	src.Add("Accept", "application/json")
+	src.Set("Content-Length", "42")
+	var dest headers
+	if err := marshaler.NewDecoder("header", marshaler.ConvertStringToNumber, marshaler.ConvertDuration).DecodeMulti(src, &dest, http.CanonicalHeaderKey); err != nil {
+		t.Fatal(err)
+	}
+	expected := headers{"abc", []string{"text/plain", "application/json"}, 42, 30 * time.Second}
+	if !reflect.DeepEqual(dest, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, dest)
+	}
+
+	// Without canonical keys, the names do not match
+	if err := marshaler.NewDecoder("header", marshaler.ConvertStringToNumber, marshaler.ConvertDuration).DecodeMulti(src, &headers{}, nil); !errors.Is(err, marshaler.ErrRequired) {
+		t.Errorf("Unexpected %v", err)
+	}
+}
+
+func Test_Multi_002(t *testing.T) {
+	// Keys with different case are merged
+	src := textproto.MIMEHeader{"accept": {"a"}, "ACCEPT": {"b"}, "x-request-id": {"1"}, "X-Timeout": {"1s"}}
+	var dest headers
+	if err := marshaler.NewDecoder("header", marshaler.ConvertStringToNumber, marshaler.ConvertDuration).DecodeMulti(src, &dest, strings.ToLower); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dest.Accept, []string{"b", "a"}) || dest.Timeout != time.Second {
+		t.Errorf("Unexpected %+v", dest)
+	}
+}
+
+func Test_Multi_003(t *testing.T) {
+	// Errors for multiple scalar values, and unknown keys in strict mode
+	src := map[string][]string{"x-request-id": {"1", "2"}, "other": {"x"}}
+	err := marshaler.NewDecoder("header", marshaler.ConvertStringToNumber, marshaler.ConvertDuration).SetStrict(true).DecodeMulti(src, &headers{}, nil)
+	var errs marshaler.Errors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("Unexpected %v", err)
+	}
+	if !errors.Is(errs[0], marshaler.ErrTypeMismatch) || !errors.Is(errs[1], marshaler.ErrUnknownField) {
+		t.Errorf("Unexpected %v", err)
+	}
+	var fieldErr *marshaler.FieldError
+	if !errors.As(errs[0], &fieldErr) || fieldErr.Path != "x-request-id" {
+		t.Errorf("Unexpected %v", errs[0])
+	}
+	if !errors.As(errs[1], &fieldErr) || fieldErr.Path != "other" {
+		t.Errorf("Unexpected %v", errs[1])
+	}
+	if err := marshaler.NewDecoder("header", marshaler.ConvertStringToNumber, marshaler.ConvertDuration).DecodeMulti(src, headers{}, nil); !errors.Is(err, marshaler.ErrBadParameter) {
+		t.Errorf("Unexpected %v", err)
+	}
+}
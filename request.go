@@ -0,0 +1,81 @@
+package marshaler
+
+import (
+	"net/http"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// GLOBALS
+
+const (
+	// Maximum memory used when parsing multipart forms
+	maxFormMemory = 32 << 20
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// PUBLIC METHODS
+
+// DecodeRequest decodes a request into dest, which should be a pointer to a
+// structure. The "in" tag option selects the source of each field, which is
+// one of query (the default), form, header, cookie or path, where path
+// values are matched by http.ServeMux patterns. Each source value is
+// converted with ConvertQueryValues and then the conversion functions
+func (this *Decoder) DecodeRequest(r *http.Request, dest interface{}) error {
+	if r == nil {
+		return ErrBadParameter.With("DecodeRequest: nil request")
+	}
+	d, err := structDest(dest, "DecodeRequest")
+	if err != nil {
+		return err
+	}
+	return this.decodeValues(d, func(field structField) ([]string, error) {
+		return requestValues(r, field)
+	}, nil)
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+// requestValues returns the values for a field from the source selected by
+// the "in" tag option
+func requestValues(r *http.Request, field structField) ([]string, error) {
+	in, _ := field.tagValue("in")
+	switch in {
+	case "", "query":
+		return r.URL.Query()[field.name], nil
+	case "form":
+		if err := parseForm(r); err != nil {
+			return nil, ErrParse.Wrap(err)
+		}
+		return r.PostForm[field.name], nil
+	case "header":
+		return r.Header.Values(field.name), nil
+	case "cookie":
+		var result []string
+		for _, cookie := range r.Cookies() {
+			if cookie.Name == field.name {
+				result = append(result, cookie.Value)
+			}
+		}
+		return result, nil
+	case "path":
+		if value := r.PathValue(field.name); value != "" {
+			return []string{value}, nil
+		}
+		return nil, nil
+	default:
+		return nil, ErrBadParameter.With("DecodeRequest: invalid source ", in, " for ", field.name)
+	}
+}
+
+// parseForm parses the request body as a form, once
+func parseForm(r *http.Request) error {
+	if r.PostForm != nil {
+		return nil
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return r.ParseMultipartForm(maxFormMemory)
+	}
+	return r.ParseForm()
+}
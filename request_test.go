@@ -0,0 +1,86 @@
+package marshaler_test
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djthorpe/go-marshaler"
+)
+
+type request struct {
+	Id      int           `http:"id,in=path"`
+	Limit   uint          `http:"limit,default=10"`
+	Tags    []string      `http:"tag,in=query"`
+	Name    string        `http:"name,in=form"`
+	Timeout time.Duration `http:"timeout,in=form"`
+	Token   string        `http:"Authorization,in=header,required"`
+	IP      net.IP        `http:"X-Forwarded-For,in=header"`
+	Session *string       `http:"session,in=cookie"`
+}
+
+func Test_Request_001(t *testing.T) {
+	var dest request
+	form := url.Values{"name": {"server"}, "timeout": {"5s"}}
+	r := httptest.NewRequest(http.MethodPost, "/servers/42?tag=a&tag=b", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Authorization", "Bearer token")
+	r.Header.Set("X-Forwarded-For", "10.0.0.1")
+	r.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+	r.SetPathValue("id", "42")
+	if err := marshaler.NewDecoder("http", marshaler.ConvertStringToNumber, marshaler.ConvertDuration).DecodeRequest(r, &dest); err != nil {
+		t.Fatal(err)
+	}
+
+	session := "abc"
+	expected := request{
+		Id:      42,
+		Limit:   10,
+		Tags:    []string{"a", "b"},
+		Name:    "server",
+		Timeout: 5 * time.Second,
+		Token:   "Bearer token",
+		IP:      net.ParseIP("10.0.0.1"),
+		Session: &session,
+	}
+	if !reflect.DeepEqual(dest, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, dest)
+	}
+}
+
+func Test_Request_002(t *testing.T) {
+	// Errors include the field name
+	var dest request
+	r := httptest.NewRequest(http.MethodGet, "/?limit=x&limit=y", nil)
+	err := marshaler.NewDecoder("http", marshaler.ConvertStringToNumber, marshaler.ConvertDuration).DecodeRequest(r, &dest)
+	var errs marshaler.Errors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("Unexpected %v", err)
+	}
+	var fieldErr *marshaler.FieldError
+	if !errors.As(errs[0], &fieldErr) || fieldErr.Path != "limit" || !errors.Is(errs[0], marshaler.ErrTypeMismatch) || fieldErr.Src != reflect.TypeOf([]string{}) {
+		t.Errorf("Unexpected %v", errs[0])
+	}
+	if !errors.As(errs[1], &fieldErr) || fieldErr.Path != "Authorization" || !errors.Is(errs[1], marshaler.ErrRequired) {
+		t.Errorf("Unexpected %v", errs[1])
+	}
+}
+
+func Test_Request_003(t *testing.T) {
+	var dest struct {
+		Value string `http:"value,in=body"`
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := marshaler.NewDecoder("http", marshaler.ConvertStringToNumber, marshaler.ConvertDuration).DecodeRequest(r, &dest); !errors.Is(err, marshaler.ErrBadParameter) {
+		t.Errorf("Unexpected %v", err)
+	}
+	if err := marshaler.NewDecoder("http", marshaler.ConvertStringToNumber, marshaler.ConvertDuration).DecodeRequest(r, dest); !errors.Is(err, marshaler.ErrBadParameter) {
+		t.Errorf("Unexpected %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package marshaler
+
+import (
+	"database/sql"
+	"reflect"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// TYPES
+
+// nullable scans a column into a field, where NULL sets the field to the
+// zero value (or nil for pointers) and other values are converted to the
+// field type
+type nullable struct {
+	column string
+	field  reflect.Value
+}
+
+// discard scans a column which does not match any field
+type discard struct{}
+
+///////////////////////////////////////////////////////////////////////////////
+// GLOBALS
+
+var (
+	// Conversion functions for values scanned into fields
+	scanDecoder = NewDecoder("", ConvertBytes, ConvertIntUint, ConvertStringToNumber, ConvertTime, ConvertDuration)
+
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// PUBLIC METHODS
+
+// DecodeRows decodes every row into dest, which should be a pointer to a
+// slice of structures, pointers to structures or maps. Columns are matched
+// to field tag names, and values from the driver are passed through the
+// conversion functions, where NULL values leave fields unchanged. The rows
+// are closed when decoding is complete
+func (this *Decoder) DecodeRows(rows *sql.Rows, dest interface{}) error {
+	if rows == nil {
+		return ErrBadParameter.With("DecodeRows: nil rows")
+	}
+	defer rows.Close()
+
+	// Destination should be a pointer to a slice
+	d := reflect.ValueOf(dest)
+	if d.Kind() != reflect.Ptr || d.IsNil() || d.Elem().Kind() != reflect.Slice {
+		return ErrBadParameter.With("DecodeRows: destination should be ptr to slice")
+	} else {
+		d = d.Elem()
+	}
+
+	// Get the columns
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	// Check for columns which do not match any field in strict mode
+	u := &unmarshaler{name: this.name, fn: this.unmarshalscalar, max: this.maxErrors}
+	var result Errors
+	if this.strict {
+		result = this.appendUnknownColumns(u, result, columns, d.Type().Elem())
+	}
+
+	// Scan each row into a map, and decode the map into a new element
+	values := make([]interface{}, len(columns))
+	scan := make([]interface{}, len(columns))
+	for i := range values {
+		scan[i] = &values[i]
+	}
+	slice := reflect.MakeSlice(d.Type(), 0, 0)
+	for i := 0; !u.done() && rows.Next(); i++ {
+		if err := rows.Scan(scan...); err != nil {
+			return err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for j, column := range columns {
+			row[column] = values[j]
+		}
+		elem := reflect.New(d.Type().Elem()).Elem()
+		if err := u.unmarshalValue(reflect.ValueOf(row), elem, indexPath("", i)); err != nil {
+			result = u.appendError(result, err)
+		}
+		slice = reflect.Append(slice, elem)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Set the destination and return any errors
+	d.Set(slice)
+	return result.errorOrNil()
+}
+
+// ScanTargets returns pointers to the fields of a structure for each column,
+// which can be passed to rows.Scan. The destination should be a pointer to
+// a structure. Fields which implement sql.Scanner are returned directly, and
+// other fields are wrapped so that NULL values set the zero value. Columns
+// which do not match any field are discarded
+func (this *Encoder) ScanTargets(dest interface{}, columns []string) ([]interface{}, error) {
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return nil, ErrBadParameter.With("ScanTargets: destination should be ptr to struct")
+	} else {
+		rv = rv.Elem()
+	}
+
+	// Index the fields by name
+	plan := structFields(rv.Type(), this.name)
+	fields := make(map[string]*structField, len(plan.fields))
+	for i := range plan.fields {
+		fields[plan.fields[i].name] = &plan.fields[i]
+	}
+
+	// Return a target for each column
+	result := make([]interface{}, len(columns))
+	for i, column := range columns {
+		field, exists := fields[column]
+		if !exists {
+			result[i] = discard{}
+			continue
+		}
+		value := fieldByIndex(rv, field.index, true)
+		if reflect.PtrTo(value.Type()).Implements(scannerType) {
+			result[i] = value.Addr().Interface()
+		} else {
+			result[i] = &nullable{column, value}
+		}
+	}
+
+	// Return success
+	return result, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+// Scan sets the field from a column value
+func (n *nullable) Scan(src interface{}) error {
+	if src == nil {
+		n.field.Set(reflect.Zero(n.field.Type()))
+		return nil
+	}
+	u := &unmarshaler{fn: scanDecoder.unmarshalscalar}
+	if src, ok := src.([]byte); ok {
+		// Copy bytes, which may be reused by the driver
+		return u.unmarshalValue(reflect.ValueOf(append([]byte{}, src...)), n.field, n.column)
+	}
+	return u.unmarshalValue(reflect.ValueOf(src), n.field, n.column)
+}
+
+// Scan ignores a column value
+func (discard) Scan(src interface{}) error {
+	return nil
+}
+
+// appendUnknownColumns appends an error for each column which does not match
+// a field of a structure element type
+func (this *Decoder) appendUnknownColumns(u *unmarshaler, result Errors, columns []string, t reflect.Type) Errors {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return result
+	}
+	plan := structFields(t, this.name)
+	for _, column := range columns {
+		if !plan.names[column] && !u.done() {
+			result = u.appendError(result, newFieldError(column, nil, nil, ErrUnknownField))
+		}
+	}
+	return result
+}
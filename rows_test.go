@@ -0,0 +1,281 @@
+package marshaler_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/djthorpe/go-marshaler"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// FAKE DRIVER
+
+// fakeDriver returns rows from a table, where the query is the table name
+type fakeDriver struct{}
+type fakeConn struct{}
+type fakeStmt struct{ query string }
+type fakeRows struct {
+	table *fakeTable
+	row   int
+}
+
+type fakeTable struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+var fakeTables = map[string]*fakeTable{
+	"servers": {
+		columns: []string{"id", "name", "port", "created", "timeout", "tags"},
+		rows: [][]driver.Value{
+			{int64(1), []byte("alpha"), int64(80), time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), []byte("5s"), []byte("a")},
+			{int64(2), []byte("beta"), nil, nil, nil, nil},
+			{int64(3), "gamma", []byte("8080"), []byte("2021-01-01T00:00:00Z"), "1m", []byte{}},
+		},
+	},
+	"errors": {
+		columns: []string{"id", "port", "other"},
+		rows: [][]driver.Value{
+			{int64(1), int64(-1), nil},
+			{int64(2), int64(80), nil},
+			{int64(3), int64(70000), nil},
+		},
+	},
+}
+
+func init() {
+	sql.Register("marshaler", fakeDriver{})
+}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	return fakeConn{}, nil
+}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{query}, nil
+}
+
+func (fakeConn) Close() error {
+	return nil
+}
+
+func (fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+func (fakeStmt) Close() error {
+	return nil
+}
+
+func (fakeStmt) NumInput() int {
+	return -1
+}
+
+func (fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+
+func (stmt fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if table, exists := fakeTables[stmt.query]; exists {
+		return &fakeRows{table: table}, nil
+	}
+	return nil, errors.New("no such table")
+}
+
+func (rows *fakeRows) Columns() []string {
+	return rows.table.columns
+}
+
+func (rows *fakeRows) Close() error {
+	return nil
+}
+
+func (rows *fakeRows) Next(dest []driver.Value) error {
+	if rows.row >= len(rows.table.rows) {
+		return io.EOF
+	}
+	copy(dest, rows.table.rows[rows.row])
+	rows.row++
+	return nil
+}
+
+func query(t *testing.T, table string) *sql.Rows {
+	t.Helper()
+	db, err := sql.Open("marshaler", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	rows, err := db.Query(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// TESTS
+
+type row struct {
+	Id      int           `sql:"id"`
+	Name    string        `sql:"name"`
+	Port    uint16        `sql:"port"`
+	Created *time.Time    `sql:"created"`
+	Timeout time.Duration `sql:"timeout"`
+	Tags    []byte        `sql:"tags"`
+}
+
+func Test_Rows_001(t *testing.T) {
+	var dest []row
+	if err := marshaler.NewDecoder("sql", marshaler.ConvertBytes, marshaler.ConvertIntUint, marshaler.ConvertStringToNumber, marshaler.ConvertTime, marshaler.ConvertDuration).DecodeRows(query(t, "servers"), &dest); err != nil {
+		t.Fatal(err)
+	}
+	if len(dest) != 3 {
+		t.Fatalf("Expected 3 rows, got %v", len(dest))
+	}
+	if r := dest[0]; r.Id != 1 || r.Name != "alpha" || r.Port != 80 || !r.Created.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) || r.Timeout != 5*time.Second || string(r.Tags) != "a" {
+		t.Errorf("Unexpected %+v", r)
+	}
+	if r := dest[1]; r.Id != 2 || r.Name != "beta" || r.Port != 0 || r.Created != nil || r.Timeout != 0 || r.Tags != nil {
+		t.Errorf("Unexpected %+v", r)
+	}
+	if r := dest[2]; r.Name != "gamma" || r.Port != 8080 || r.Created.Year() != 2021 || r.Timeout != time.Minute || len(r.Tags) != 0 {
+		t.Errorf("Unexpected %+v", r)
+	}
+}
+
+func Test_Rows_002(t *testing.T) {
+	// Pointers to structures and maps
+	var ptrs []*row
+	if err := marshaler.NewDecoder("sql", marshaler.ConvertBytes, marshaler.ConvertIntUint, marshaler.ConvertStringToNumber, marshaler.ConvertTime, marshaler.ConvertDuration).DecodeRows(query(t, "servers"), &ptrs); err != nil {
+		t.Fatal(err)
+	} else if len(ptrs) != 3 || ptrs[1].Name != "beta" {
+		t.Errorf("Unexpected %v", ptrs)
+	}
+	var maps []map[string]interface{}
+	if err := marshaler.NewDecoder("sql", marshaler.ConvertBytes, marshaler.ConvertIntUint, marshaler.ConvertStringToNumber, marshaler.ConvertTime, marshaler.ConvertDuration).DecodeRows(query(t, "servers"), &maps); err != nil {
+		t.Fatal(err)
+	} else if len(maps) != 3 || maps[0]["id"] != int64(1) || string(maps[0]["name"].([]byte)) != "alpha" {
+		t.Errorf("Unexpected %v", maps)
+	}
+}
+
+func Test_Rows_003(t *testing.T) {
+	// Errors include the row index and column
+	var dest []row
+	err := marshaler.NewDecoder("sql", marshaler.ConvertBytes, marshaler.ConvertIntUint, marshaler.ConvertStringToNumber, marshaler.ConvertTime, marshaler.ConvertDuration).DecodeRows(query(t, "errors"), &dest)
+	var errs marshaler.Errors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("Unexpected %v", err)
+	}
+	var fieldErr *marshaler.FieldError
+	if !errors.As(errs[0], &fieldErr) || fieldErr.Path != "[0].port" || !errors.Is(fieldErr, marshaler.ErrOutOfRange) {
+		t.Errorf("Unexpected %v", errs[0])
+	}
+	if !errors.As(errs[1], &fieldErr) || fieldErr.Path != "[2].port" {
+		t.Errorf("Unexpected %v", errs[1])
+	}
+	if len(dest) != 3 || dest[1].Port != 80 {
+		t.Errorf("Unexpected %v", dest)
+	}
+}
+
+func Test_Rows_004(t *testing.T) {
+	// Unknown columns in strict mode, and maximum errors
+	var dest []row
+	err := marshaler.NewDecoder("sql", marshaler.ConvertBytes, marshaler.ConvertIntUint, marshaler.ConvertStringToNumber, marshaler.ConvertTime, marshaler.ConvertDuration).SetStrict(true).DecodeRows(query(t, "errors"), &dest)
+	if !errors.Is(err, marshaler.ErrUnknownField) {
+		t.Errorf("Unexpected %v", err)
+	}
+	err = marshaler.NewDecoder("sql", marshaler.ConvertBytes, marshaler.ConvertIntUint, marshaler.ConvertStringToNumber, marshaler.ConvertTime, marshaler.ConvertDuration).SetMaxErrors(1).DecodeRows(query(t, "errors"), &dest)
+	var errs marshaler.Errors
+	if !errors.As(err, &errs) || len(errs) != 1 || len(dest) != 1 {
+		t.Errorf("Unexpected %v %v", err, dest)
+	}
+}
+
+func Test_Rows_005(t *testing.T) {
+	var dest []row
+	if err := marshaler.NewDecoder("sql", marshaler.ConvertBytes, marshaler.ConvertIntUint, marshaler.ConvertStringToNumber, marshaler.ConvertTime, marshaler.ConvertDuration).DecodeRows(query(t, "servers"), dest); !errors.Is(err, marshaler.ErrBadParameter) {
+		t.Errorf("Unexpected %v", err)
+	}
+	if err := marshaler.NewDecoder("sql", marshaler.ConvertBytes, marshaler.ConvertIntUint, marshaler.ConvertStringToNumber, marshaler.ConvertTime, marshaler.ConvertDuration).DecodeRows(nil, &dest); !errors.Is(err, marshaler.ErrBadParameter) {
+		t.Errorf("Unexpected %v", err)
+	}
+}
+
+func Test_Rows_006(t *testing.T) {
+	// Scan into structures with the scan targets
+	type scanRow struct {
+		Id      int32          `sql:"id"`
+		Name    sql.NullString `sql:"name"`
+		Port    *uint16        `sql:"port"`
+		Created time.Time      `sql:"created"`
+		Tags    []byte         `sql:"tags"`
+	}
+	rows := query(t, "servers")
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result []scanRow
+	enc := marshaler.NewEncoder("sql")
+	for rows.Next() {
+		var dest scanRow
+		targets, err := enc.ScanTargets(&dest, columns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rows.Scan(targets...); err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, dest)
+	}
+	if len(result) != 3 {
+		t.Fatalf("Expected 3 rows, got %v", len(result))
+	}
+	if r := result[0]; r.Id != 1 || r.Name.String != "alpha" || *r.Port != 80 || r.Created.Year() != 2020 || string(r.Tags) != "a" {
+		t.Errorf("Unexpected %+v", r)
+	}
+	if r := result[1]; r.Id != 2 || !r.Name.Valid || r.Port != nil || !r.Created.IsZero() || r.Tags != nil {
+		t.Errorf("Unexpected %+v", r)
+	}
+	if r := result[2]; r.Name.String != "gamma" || *r.Port != 8080 || r.Created.Year() != 2021 {
+		t.Errorf("Unexpected %+v", r)
+	}
+}
+
+func Test_Rows_007(t *testing.T) {
+	// NULL sets the zero value, unknown columns are discarded
+	dest := row{Id: 1, Name: "name"}
+	targets, err := marshaler.NewEncoder("sql").ScanTargets(&dest, []string{"name", "other", "port"})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(targets) != 3 {
+		t.Fatalf("Unexpected %v", targets)
+	}
+	for _, target := range targets {
+		if err := target.(sql.Scanner).Scan(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dest.Id != 1 || dest.Name != "" {
+		t.Errorf("Unexpected %+v", dest)
+	}
+
+	// Conversion errors include the column
+	var fieldErr *marshaler.FieldError
+	if err := targets[2].(sql.Scanner).Scan(int64(-1)); !errors.As(err, &fieldErr) || fieldErr.Path != "port" || !errors.Is(err, marshaler.ErrOutOfRange) {
+		t.Errorf("Unexpected %v", err)
+	}
+
+	// Destination should be a pointer to a structure
+	if _, err := marshaler.NewEncoder("sql").ScanTargets(dest, nil); !errors.Is(err, marshaler.ErrBadParameter) {
+		t.Errorf("Unexpected %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package marshaler
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// TYPES
+
+// Placeholder is the style of placeholders for arguments in SQL statements
+type Placeholder int
+
+///////////////////////////////////////////////////////////////////////////////
+// CONSTANTS
+
+const (
+	// PlaceholderQuestion formats placeholders as ?
+	PlaceholderQuestion Placeholder = iota
+
+	// PlaceholderDollar formats placeholders as $1, $2, $3
+	PlaceholderDollar
+)
+
+///////////////////////////////////////////////////////////////////////////////
+// PUBLIC METHODS
+
+// Format returns the placeholder for the n'th argument, counting from one
+func (p Placeholder) Format(n int) string {
+	switch p {
+	case PlaceholderDollar:
+		return "$" + strconv.Itoa(n)
+	default:
+		return "?"
+	}
+}
+
+// List returns a comma-separated list of count placeholders, for the
+// arguments counting from start
+func (p Placeholder) List(start, count int) string {
+	var str strings.Builder
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			str.WriteString(", ")
+		}
+		str.WriteString(p.Format(start + i))
+	}
+	return str.String()
+}
+
+// Columns returns the column names of a structure (or pointer to structure,
+// which can be nil) in field order, for example for SELECT statements
+func (this *Encoder) Columns(v interface{}) ([]string, error) {
+	rv, err := sqlStruct(v, "Columns")
+	if err != nil {
+		return nil, err
+	}
+	fields := this.reflect(rv, true)
+	result := make([]string, 0, len(fields))
+	for _, field := range fields {
+		result = append(result, field.Name)
+	}
+	return result, nil
+}
+
+// InsertColumns returns the column names and arguments in the same order for
+// an INSERT statement. Fields with the "readonly" tag option, and empty fields
+// with the "omitempty" tag option, are not included. Arguments are the field
+// values, which are converted by the database driver
+func (this *Encoder) InsertColumns(v interface{}) ([]string, []interface{}, error) {
+	rv, err := sqlStruct(v, "InsertColumns")
+	if err != nil {
+		return nil, nil, err
+	}
+	var columns []string
+	var args []interface{}
+	for _, field := range this.reflect(rv, true) {
+		if field.HasTag("readonly") || field.omit() {
+			continue
+		}
+		columns = append(columns, field.Name)
+		args = append(args, field.Value.Interface())
+	}
+	return columns, args, nil
+}
+
+// UpdateSet returns the "column = placeholder" clauses separated by commas,
+// and arguments in the same order, for the SET clause of an UPDATE statement.
+// Placeholders count from start. Fields with the "readonly" or "pk" tag
+// options, and empty fields with the "omitempty" tag option, are not included
+func (this *Encoder) UpdateSet(v interface{}, p Placeholder, start int) (string, []interface{}, error) {
+	rv, err := sqlStruct(v, "UpdateSet")
+	if err != nil {
+		return "", nil, err
+	}
+	var args []interface{}
+	var clauses []string
+	for _, field := range this.reflect(rv, true) {
+		if field.HasTag("readonly") || field.HasTag("pk") || field.omit() {
+			continue
+		}
+		clauses = append(clauses, field.Name+" = "+p.Format(start+len(args)))
+		args = append(args, field.Value.Interface())
+	}
+	return strings.Join(clauses, ", "), args, nil
+}
+
+// PrimaryKey returns the "column = placeholder" clauses separated by AND,
+// and arguments in the same order, for fields with the "pk" tag option. This
+// can be used for the WHERE clause of an UPDATE statement. Placeholders count
+// from start
+func (this *Encoder) PrimaryKey(v interface{}, p Placeholder, start int) (string, []interface{}, error) {
+	rv, err := sqlStruct(v, "PrimaryKey")
+	if err != nil {
+		return "", nil, err
+	}
+	var args []interface{}
+	var clauses []string
+	for _, field := range this.reflect(rv, true) {
+		if !field.HasTag("pk") {
+			continue
+		}
+		clauses = append(clauses, field.Name+" = "+p.Format(start+len(args)))
+		args = append(args, field.Value.Interface())
+	}
+	if len(clauses) == 0 {
+		return "", nil, ErrBadParameter.With("PrimaryKey: no fields with pk tag option")
+	}
+	return strings.Join(clauses, " AND "), args, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+// sqlStruct returns the structure value from a structure or pointer to
+// structure, where a nil pointer returns the zero value
+func sqlStruct(v interface{}, method string) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv = reflect.Zero(rv.Type().Elem())
+		} else {
+			rv = rv.Elem()
+		}
+	}
+	if rv.Kind() != reflect.Struct {
+		return reflect.Value{}, ErrBadParameter.With(method, ": expected struct but got ", rv.Kind())
+	}
+	return rv, nil
+}
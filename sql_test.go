@@ -0,0 +1,96 @@
+package marshaler_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/djthorpe/go-marshaler"
+)
+
+type sqlAudit struct {
+	Created time.Time `db:"created,readonly"`
+}
+
+type sqlServer struct {
+	Id   int    `db:"id,pk,omitempty"`
+	Name string `db:"name"`
+	Port *int   `db:"port,omitempty"`
+	sqlAudit
+	Notes  string `db:"-"`
+	Region string `db:"region,pk"`
+}
+
+func Test_SQL_001(t *testing.T) {
+	tests := []struct {
+		p      marshaler.Placeholder
+		start  int
+		count  int
+		result string
+	}{
+		{marshaler.PlaceholderQuestion, 1, 3, "?, ?, ?"},
+		{marshaler.PlaceholderDollar, 1, 3, "$1, $2, $3"},
+		{marshaler.PlaceholderDollar, 4, 2, "$4, $5"},
+		{marshaler.PlaceholderDollar, 1, 0, ""},
+	}
+	for _, test := range tests {
+		if result := test.p.List(test.start, test.count); result != test.result {
+			t.Errorf("Expected %q, got %q", test.result, result)
+		}
+	}
+}
+
+func Test_SQL_002(t *testing.T) {
+	enc := marshaler.NewEncoder("db")
+	if columns, err := enc.Columns((*sqlServer)(nil)); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(columns, []string{"id", "name", "port", "created", "region"}) {
+		t.Errorf("Unexpected %v", columns)
+	}
+	if _, err := enc.Columns(1); !errors.Is(err, marshaler.ErrBadParameter) {
+		t.Errorf("Unexpected %v", err)
+	}
+}
+
+func Test_SQL_003(t *testing.T) {
+	enc := marshaler.NewEncoder("db")
+	port := 80
+	columns, args, err := enc.InsertColumns(sqlServer{Name: "server", Port: &port, Region: "eu"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(columns, []string{"name", "port", "region"}) {
+		t.Errorf("Unexpected %v", columns)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"server", &port, "eu"}) {
+		t.Errorf("Unexpected %v", args)
+	}
+}
+
+func Test_SQL_004(t *testing.T) {
+	enc := marshaler.NewEncoder("db")
+	server := sqlServer{Id: 1, Name: "server", Region: "eu"}
+	set, args, err := enc.UpdateSet(server, marshaler.PlaceholderDollar, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set != "name = $1" || !reflect.DeepEqual(args, []interface{}{"server"}) {
+		t.Errorf("Unexpected %q %v", set, args)
+	}
+	where, pk, err := enc.PrimaryKey(server, marshaler.PlaceholderDollar, len(args)+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if where != "id = $2 AND region = $3" || !reflect.DeepEqual(pk, []interface{}{1, "eu"}) {
+		t.Errorf("Unexpected %q %v", where, pk)
+	}
+	if where, _, err := enc.PrimaryKey(server, marshaler.PlaceholderQuestion, 1); err != nil {
+		t.Fatal(err)
+	} else if where != "id = ? AND region = ?" {
+		t.Errorf("Unexpected %q", where)
+	}
+	if _, _, err := enc.PrimaryKey(sqlAudit{}, marshaler.PlaceholderQuestion, 1); !errors.Is(err, marshaler.ErrBadParameter) {
+		t.Errorf("Unexpected %v", err)
+	}
+}
@@ -1,44 +1,61 @@
 package marshaler
 
 import (
-	"errors"
 	"reflect"
+	"strconv"
 	"strings"
-	"unicode"
 )
 
 ///////////////////////////////////////////////////////////////////////////////
 // TYPES
 
+// Unmarshaler is implemented by types which decode themselves from the
+// source value
+type Unmarshaler interface {
+	UnmarshalValue(src interface{}) error
+}
+
 // Custom function for converting a scalar value, the first argument is
 // the source value and the second argument is the type of the destination
 type UnmarshalScalarFunc func(reflect.Value, reflect.Type) (reflect.Value, error)
 
+// unmarshaler holds the tag name and scalar conversion function used when
+// unmarshaling values, any source keys which did not match a field, and the
+// count of errors, where decoding stops when the maximum count is reached.
+// In merge mode, nil values set the destination to the zero value and maps
+// are merged into existing maps. Direct is true when the conversion function
+// returns values of the destination type unchanged
+type unmarshaler struct {
+	name   string
+	fn     UnmarshalScalarFunc
+	direct bool
+	track  bool
+	merge  bool
+	unused []string
+	max    int
+	count  int
+}
+
 ///////////////////////////////////////////////////////////////////////////////
 // PUBLIC METHODS
 
-// UnmarshalSlice will decode src into a slice
+// UnmarshalSlice will decode src into a slice. Field names of any structures
+// within the slice are identified by their field name
 func UnmarshalSlice(src, dst interface{}, fn UnmarshalScalarFunc) error {
-	s := reflect.ValueOf(src)
-	d := reflect.ValueOf(dst)
-	if d.Kind() != reflect.Ptr {
-		return ErrBadParameter.With("destination should be ptr to slice")
-	} else {
-		d = d.Elem()
-	}
-
-	// Check source and destination
-	if s.Kind() != reflect.Slice {
-		return ErrBadParameter.With("source should be a slice")
-	} else if d.Kind() != reflect.Slice {
-		return ErrBadParameter.With("destination should be a slice")
-	}
-
-	return unmarshalValue(s, d, fn)
+	return (&unmarshaler{name: "", fn: fn, direct: passThrough(fn)}).unmarshalSlice(src, dst)
 }
 
-// UnmarshalStruct will decode src into dest field names identified by tag
+// UnmarshalStruct will decode src into dest field names identified by tag.
+// Nested maps are decoded into nested structures
 func UnmarshalStruct(src, dst interface{}, name string, fn UnmarshalScalarFunc) error {
+	return (&unmarshaler{name: name, fn: fn, direct: passThrough(fn)}).unmarshal(src, dst)
+}
+
+///////////////////////////////////////////////////////////////////////////////
+// PRIVATE METHODS
+
+// unmarshal decodes a map into a pointer to a struct or map
+func (this *unmarshaler) unmarshal(src, dst interface{}) error {
 	s := reflect.ValueOf(src)
 	d := reflect.ValueOf(dst)
 
@@ -54,48 +71,92 @@ func UnmarshalStruct(src, dst interface{}, name string, fn UnmarshalScalarFunc)
 		d = d.Elem()
 	}
 
-	return unmarshalStruct(s, d, name, fn)
+	return this.unmarshalStruct(s, d, "")
+}
+
+// unmarshalSlice decodes a slice into a pointer to a slice
+func (this *unmarshaler) unmarshalSlice(src, dst interface{}) error {
+	s := reflect.ValueOf(src)
+	d := reflect.ValueOf(dst)
+	if d.Kind() != reflect.Ptr {
+		return ErrBadParameter.With("destination should be ptr to slice")
+	} else {
+		d = d.Elem()
+	}
+
+	// Check source and destination
+	if s.Kind() != reflect.Slice {
+		return ErrBadParameter.With("source should be a slice")
+	} else if d.Kind() != reflect.Slice {
+		return ErrBadParameter.With("destination should be a slice")
+	}
+
+	return this.unmarshalValue(s, d, "")
 }
 
-func unmarshalStruct(s, d reflect.Value, name string, fn UnmarshalScalarFunc) error {
-	var result error
+// unmarshalStruct decodes a map into a struct or map, where path is the
+// location of the map within the source
+func (this *unmarshaler) unmarshalStruct(s, d reflect.Value, path string) error {
+	var result Errors
 	switch d.Kind() {
 	case reflect.Struct:
-		// Unmarshal into each field
-		fields := reflect.VisibleFields(d.Type())
-		for _, field := range fields {
-			tag := tagName(field, name)
-			if tag == "" {
-				continue
+		// Use generated methods if implemented, except when merging
+		if !this.merge {
+			if ok, err := this.unmarshalMap(s, d, path); ok {
+				return err
 			}
+		}
 
-			// Recurse into anonymous fields
-			if field.Anonymous {
-				continue
+		// Unmarshal into each field, embedded pointers are allocated when any
+		// of their fields are present in the source
+		plan := structFields(d.Type(), this.name)
+		for _, field := range plan.fields {
+			if this.done() {
+				break
 			}
 
-			// Get source value
-			v := s.MapIndex(reflect.ValueOf(tag))
+			// Get source value, which is required or can be set from a default.
+			// Missing values are left unchanged when merging
+			v := s.MapIndex(field.key)
 			if !v.IsValid() {
-				continue
+				if this.merge {
+					continue
+				} else if field.required {
+					result = this.appendError(result, newFieldError(joinPath(path, field.name), nil, field.Type, ErrRequired))
+					continue
+				} else if field.hasDefault {
+					v = reflect.ValueOf(field.def)
+					if t := elemType(field.Type); t.Kind() == reflect.Slice && !reflect.PtrTo(t).Implements(textUnmarshalerType) {
+						v = reflect.ValueOf(splitList(field.def))
+					}
+				} else {
+					continue
+				}
 			}
 
 			// Unmarshal into field
-			if err := unmarshalValue(v, d.FieldByIndex(field.Index), fn); err != nil {
-				result = errors.Join(result, err)
+			if err := this.unmarshalValue(v, fieldByIndex(d, field.index, true), joinPath(path, field.name)); err != nil {
+				result = this.appendError(result, err)
 			}
 		}
+
+		// Record source keys which do not match any field
+		if this.track {
+			this.appendUnused(s, plan, path)
+		}
 	case reflect.Map:
 		// Check for unallocated map
 		if d.IsNil() {
 			d.Set(reflect.MakeMap(d.Type()))
+		} else if this.merge {
+			return this.mergeMap(s, d, path)
 		}
 		// Unmarshal into map
 		iter := s.MapRange()
-		for iter.Next() {
+		for iter.Next() && !this.done() {
 			dv := reflect.New(d.Type().Elem()).Elem()
-			if err := unmarshalValue(iter.Value(), dv, fn); err != nil {
-				result = errors.Join(result, err)
+			if err := this.unmarshalValue(iter.Value(), dv, joinPath(path, iter.Key().String())); err != nil {
+				result = this.appendError(result, err)
 			} else {
 				d.SetMapIndex(iter.Key(), dv)
 			}
@@ -105,132 +166,335 @@ func unmarshalStruct(s, d reflect.Value, name string, fn UnmarshalScalarFunc) er
 	}
 
 	// Return any errors
-	return result
-}
-
-///////////////////////////////////////////////////////////////////////////////
-// PRIVATE METHODS
-
-// tagName returns the name of the field based on tag or field name
-// and returns empty string if the field should be ignored (not assignable)
-func tagName(field reflect.StructField, tagName string) string {
-	// Check for private field
-	if field.Name != "" && unicode.IsLower(rune(field.Name[0])) {
-		return ""
-	}
-	tags := strings.Split(field.Tag.Get(tagName), ",")
-	if tags[0] == "-" {
-		return ""
-	} else if tags[0] == "" {
-		return field.Name
-	} else {
-		return tags[0]
-	}
+	return result.errorOrNil()
 }
 
 // unmarshalValue recursively unmarshals src into dest and returns any errors if src is
-// not assignable into dest
-func unmarshalValue(src, dest reflect.Value, fn UnmarshalScalarFunc) error {
-	switch src.Kind() {
-	case reflect.Ptr:
-		src := src.Elem()
-		if src.IsValid() {
-			dest.Set(reflect.New(src.Type()))
-			return unmarshalValue(src, dest.Elem(), fn)
+// not assignable into dest. The scalar conversion function is called at every level,
+// pointers are allocated and nested maps are unmarshaled into nested structures. The
+// path is the location of the value within the source
+func (this *unmarshaler) unmarshalValue(src, dest reflect.Value, path string) error {
+	// Dereference source interfaces and pointers, nil values are skipped or
+	// set the destination to the zero value when merging
+	for src.Kind() == reflect.Interface || src.Kind() == reflect.Ptr {
+		src = src.Elem()
+	}
+	if !src.IsValid() {
+		if this.merge {
+			dest.Set(reflect.Zero(dest.Type()))
 		}
-	case reflect.Interface:
-		src := src.Elem()
+		return nil
+	}
 
-		// Call again on src.Elem
-		if src.Kind() == reflect.Slice {
-			return unmarshalValue(src, dest, fn)
+	// Use the destination's own unmarshaling methods if implemented
+	if ok, err := unmarshalInterface(src, dest); ok {
+		if err != nil {
+			return newFieldError(path, src.Type(), dest.Type(), ErrParse.Wrap(err))
 		}
+		return nil
+	}
 
-		recursive := true
-		if fn != nil {
-			if v, err := fn(src, dest.Type()); err != nil {
-				return err
-			} else if v.IsValid() {
-				recursive = false
-				src = v
-			}
+	// Convert the source value
+	if this.fn != nil {
+		if v, err := this.fn(src, dest.Type()); err != nil {
+			return newFieldError(path, src.Type(), dest.Type(), err)
+		} else if v.IsValid() {
+			src = v
 		}
+	}
 
-		// Bail if not valid
-		if !src.IsValid() {
+	switch dest.Kind() {
+	case reflect.Ptr:
+		// Set the pointer if already converted, or else allocate and recurse
+		if src.Type() == dest.Type() {
+			dest.Set(src)
 			return nil
+		} else if dest.IsNil() {
+			dest.Set(reflect.New(dest.Type().Elem()))
 		}
-
-		// Check appropriate type
-		if src.Type() != dest.Type() {
-			return ErrBadParameter.With("destination is ", dest.Type(), " but expected ", src.Type())
+		return this.unmarshalValue(src, dest.Elem(), path)
+	case reflect.Interface:
+		// Merge a map into an existing map when merging
+		if this.merge && src.Kind() == reflect.Map && !dest.IsNil() && dest.Elem().Kind() == reflect.Map && src.Type().Key().ConvertibleTo(dest.Elem().Type().Key()) {
+			return this.mergeMap(src, dest.Elem(), path)
 		}
-
-		// Make copy of src if recursive, or set otherwise
-		if recursive {
-			copyValue := reflect.New(src.Type()).Elem()
-			if err := unmarshalValue(src, copyValue, fn); err != nil {
-				return err
-			}
-			dest.Set(copyValue)
-		} else {
+		// Set any value which implements the interface
+		if !src.Type().AssignableTo(dest.Type()) {
+			return newFieldError(path, src.Type(), dest.Type(), ErrTypeMismatch)
+		}
+		dest.Set(src)
+	case reflect.Struct:
+		// Unmarshal a nested map into a nested structure
+		if src.Type() == dest.Type() {
 			dest.Set(src)
+		} else if src.Kind() == reflect.Map && src.Type().Key().Kind() == reflect.String {
+			return this.unmarshalStruct(src, dest, path)
+		} else {
+			return newFieldError(path, src.Type(), dest.Type(), ErrTypeMismatch)
 		}
 	case reflect.Map:
-		// Make a new map
-		dest.Set(reflect.MakeMap(src.Type()))
-
-		// Unmarshal each key/value pair
-		for _, key := range src.MapKeys() {
-			v := src.MapIndex(key)
-			if !v.IsNil() {
-				copy := reflect.New(v.Type()).Elem()
-				if err := unmarshalValue(v, copy, fn); err != nil {
-					return err
-				}
-				dest.SetMapIndex(key, copy)
-			}
+		// Check for both maps
+		if src.Kind() != reflect.Map || !src.Type().Key().ConvertibleTo(dest.Type().Key()) {
+			return newFieldError(path, src.Type(), dest.Type(), ErrTypeMismatch)
 		}
-	case reflect.Slice:
-		if fn != nil {
-			if v, err := fn(src, dest.Type()); err != nil {
-				return err
-			} else if v.IsValid() && v.Kind() != reflect.Slice {
-				return unmarshalValue(v, dest, fn)
-			}
+
+		// Merge into an existing map, or make a new map
+		if this.merge && !dest.IsNil() {
+			return this.mergeMap(src, dest, path)
 		}
+		dest.Set(reflect.MakeMapWithSize(dest.Type(), src.Len()))
 
+		// Unmarshal each key/value pair, collecting errors
+		var result Errors
+		iter := src.MapRange()
+		for iter.Next() && !this.done() {
+			copy := reflect.New(dest.Type().Elem()).Elem()
+			if err := this.unmarshalValue(iter.Value(), copy, joinPath(path, iter.Key().String())); err != nil {
+				result = this.appendError(result, err)
+			} else {
+				dest.SetMapIndex(iter.Key().Convert(dest.Type().Key()), copy)
+			}
+		}
+		return result.errorOrNil()
+	case reflect.Slice:
 		// Check for both slices, source can be []interface{}
-		if src.Kind() != dest.Kind() {
-			return ErrBadParameter.With("destination is ", dest.Type(), " but expected ", src.Type())
+		if src.Kind() != reflect.Slice && src.Kind() != reflect.Array {
+			return newFieldError(path, src.Type(), dest.Type(), ErrTypeMismatch)
 		}
 
 		// Make a new slice
-		dest.Set(reflect.MakeSlice(dest.Type(), src.Len(), src.Cap()))
+		dest.Set(reflect.MakeSlice(dest.Type(), src.Len(), src.Len()))
 
-		// Copy source elements
-		for i := 0; i < src.Len(); i++ {
-			if err := unmarshalValue(src.Index(i), dest.Index(i), fn); err != nil {
-				return err
+		// Copy source elements, collecting errors
+		var result Errors
+		for i := 0; i < src.Len() && !this.done(); i++ {
+			if err := this.unmarshalValue(src.Index(i), dest.Index(i), indexPath(path, i)); err != nil {
+				result = this.appendError(result, err)
 			}
 		}
-	default:
-		if fn != nil {
-			if v, err := fn(src, dest.Type()); err != nil {
-				return err
-			} else if v.IsValid() {
-				src = v
+		return result.errorOrNil()
+	case reflect.Array:
+		// Set the array if already converted, or else check for a slice or array
+		// of the same length
+		if src.Type() == dest.Type() {
+			dest.Set(src)
+			return nil
+		} else if src.Kind() != reflect.Slice && src.Kind() != reflect.Array {
+			return newFieldError(path, src.Type(), dest.Type(), ErrTypeMismatch)
+		} else if src.Len() != dest.Len() {
+			return newFieldError(path, src.Type(), dest.Type(), ErrOutOfRange.With("expected ", dest.Len(), " elements but got ", src.Len()))
+		}
+
+		// Copy source elements, collecting errors
+		var result Errors
+		for i := 0; i < src.Len() && !this.done(); i++ {
+			if err := this.unmarshalValue(src.Index(i), dest.Index(i), indexPath(path, i)); err != nil {
+				result = this.appendError(result, err)
 			}
 		}
+		return result.errorOrNil()
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer, reflect.Complex64, reflect.Complex128:
+		return newFieldError(path, src.Type(), dest.Type(), ErrUnsupported)
+	default:
 		// Check appropriate type
 		if src.Kind() != dest.Kind() {
-			return ErrBadParameter.With("destination is ", dest.Type(), " but expected ", src.Type())
+			return newFieldError(path, src.Type(), dest.Type(), ErrTypeMismatch)
 		}
 
 		// Set scalar
-		dest.Set(src)
+		dest.Set(src.Convert(dest.Type()))
 	}
 
 	// Return success
 	return nil
 }
+
+// mergeMap merges src into an existing map, where nil values delete keys and
+// values are merged into existing values
+func (this *unmarshaler) mergeMap(src, dest reflect.Value, path string) error {
+	var result Errors
+	iter := src.MapRange()
+	for iter.Next() && !this.done() {
+		key := iter.Key().Convert(dest.Type().Key())
+		if isNilSource(iter.Value()) {
+			dest.SetMapIndex(key, reflect.Value{})
+			continue
+		}
+		copy := reflect.New(dest.Type().Elem()).Elem()
+		if existing := dest.MapIndex(key); existing.IsValid() {
+			copy.Set(existing)
+		}
+		if err := this.unmarshalValue(iter.Value(), copy, joinPath(path, iter.Key().String())); err != nil {
+			result = this.appendError(result, err)
+		} else {
+			dest.SetMapIndex(key, copy)
+		}
+	}
+	return result.errorOrNil()
+}
+
+// unmarshalMap decodes a map[string]interface{} into a structure which
+// implements MapDecoder for the same tag name and type, and returns true if
+// the structure was decoded. Errors are returned relative to path
+func (this *unmarshaler) unmarshalMap(s, d reflect.Value, path string) (bool, error) {
+	if s.Type() != mapInterfaceType || !d.CanAddr() || unmarshalConverter(d.Type()) != convertMap {
+		return false, nil
+	}
+	dec := d.Addr().Interface().(MapDecoder)
+	if !hasMapMethods(dec, d.Type(), this.name) {
+		return false, nil
+	}
+	return true, this.decodeMap(s.Interface().(map[string]interface{}), dec, path)
+}
+
+// decodeMap calls DecodeMap with a decoder which continues this decode
+// relative to the map. Errors are prefixed by path, counted towards the
+// maximum count and returned as a list, and unused keys are recorded
+func (this *unmarshaler) decodeMap(src map[string]interface{}, dest MapDecoder, path string) error {
+	if this.done() {
+		return nil
+	}
+	u := &unmarshaler{name: this.name, fn: this.fn, direct: this.direct, track: this.track}
+	if this.max > 0 {
+		u.max = this.max - this.count
+	}
+	var result Errors
+	if err := dest.DecodeMap(&Decoder{name: this.name, direct: this.direct, state: u}, src); err != nil {
+		result = result.Append(prefixError(err, path))
+		if this.max > 0 && len(result) > this.max-this.count {
+			result = result[:this.max-this.count]
+		}
+		this.count += len(result)
+	}
+	if this.track {
+		for _, key := range u.unused {
+			this.unused = append(this.unused, prefixPath(key, path))
+		}
+		this.appendUnused(reflect.ValueOf(src), structFields(reflect.TypeOf(dest).Elem(), this.name), path)
+	}
+	return result.errorOrNil()
+}
+
+// prefixError returns an error with the path of any FieldError
+// prefixed by path
+func prefixError(err error, path string) error {
+	if path == "" {
+		return err
+	}
+	switch e := err.(type) {
+	case Errors:
+		result := make(Errors, len(e))
+		for i, err := range e {
+			result[i] = prefixError(err, path)
+		}
+		return result
+	case *FieldError:
+		copy := *e
+		copy.Path = prefixPath(e.Path, path)
+		return &copy
+	default:
+		return err
+	}
+}
+
+// prefixPath returns a path relative to a map prefixed by the path of
+// the map
+func prefixPath(key, path string) string {
+	if strings.HasPrefix(key, "[") {
+		return path + key
+	}
+	return joinPath(path, key)
+}
+
+// appendError appends an error to a list of errors, flattening nested lists.
+// Errors which are not lists are counted towards the maximum count
+func (this *unmarshaler) appendError(result Errors, err error) Errors {
+	if errs, ok := err.(Errors); ok {
+		return append(result, errs...)
+	}
+	this.count++
+	return append(result, err)
+}
+
+// done returns true when the maximum count of errors has been reached
+func (this *unmarshaler) done() bool {
+	return this.max > 0 && this.count >= this.max
+}
+
+// unmarshalInterface decodes src when the destination implements Unmarshaler,
+// or when it implements encoding.TextUnmarshaler and src is a string or
+// []byte. Values of time.Time are left to the scalar conversion function. It
+// returns true if the destination was decoded
+func unmarshalInterface(src, dest reflect.Value) (bool, error) {
+	if !dest.CanAddr() {
+		return false, nil
+	}
+	switch unmarshalConverter(dest.Type()) {
+	case convertUnmarshaler:
+		return true, dest.Addr().Interface().(Unmarshaler).UnmarshalValue(src.Interface())
+	case convertText:
+		if src.Type() == dest.Type() {
+			return false, nil
+		} else if src.Kind() == reflect.String {
+			return true, textUnmarshaler(dest).UnmarshalText([]byte(src.String()))
+		} else if src.Kind() == reflect.Slice && src.Type().Elem().Kind() == reflect.Uint8 {
+			return true, textUnmarshaler(dest).UnmarshalText(src.Bytes())
+		}
+	}
+	return false, nil
+}
+
+// appendUnused records the keys of a source map which do not match any field
+func (this *unmarshaler) appendUnused(s reflect.Value, plan *structPlan, path string) {
+	if s.Len() <= len(plan.names) {
+		// Check quickly when every key could match a field
+		matched := 0
+		for _, field := range plan.fields {
+			if s.MapIndex(field.key).IsValid() {
+				matched++
+			}
+		}
+		if matched == s.Len() {
+			return
+		}
+	}
+	for _, key := range s.MapKeys() {
+		if !plan.names[key.String()] {
+			this.unused = append(this.unused, joinPath(path, key.String()))
+		}
+	}
+}
+
+// newFieldError returns an error for a value at path within the source
+func newFieldError(path string, src, dest reflect.Type, err error) error {
+	key := path
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		key = key[i+1:]
+	}
+	if i := strings.Index(key, "["); i >= 0 {
+		key = key[:i]
+	}
+	return &FieldError{Path: path, Key: key, Src: src, Dest: dest, Err: err}
+}
+
+// isNilSource returns true if a source value is nil, after dereferencing
+// interfaces and pointers
+func isNilSource(v reflect.Value) bool {
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	return !v.IsValid()
+}
+
+// joinPath returns the path of a key within a map
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// indexPath returns the path of an element within a slice
+func indexPath(path string, i int) string {
+	return path + "[" + strconv.Itoa(i) + "]"
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"math/rand"
 	"net/url"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -374,3 +376,92 @@ func stringSliceEqual(a, b []string) bool {
 	}
 	return true
 }
+
+func Test_Unmarshall_014(t *testing.T) {
+	type Base struct {
+		ID   int    `yaml:"id"`
+		Name string `yaml:"name"`
+	}
+	type Meta struct {
+		Version int `yaml:"version"`
+	}
+	var dest struct {
+		*Base
+		Meta  `yaml:"meta"`
+		Name  string `yaml:"name"`
+		Other int    `yaml:"other"`
+	}
+	var src = map[string]interface{}{
+		"id":   int(55),
+		"name": "outer",
+		"meta": map[string]interface{}{"version": int(2)},
+	}
+	if err := marshaler.UnmarshalStruct(src, &dest, "yaml", nil); err != nil {
+		t.Fatal(err)
+	} else if dest.Base == nil || dest.ID != 55 {
+		t.Error("Expected embedded pointer to be allocated", dest)
+	} else if dest.Name != "outer" || dest.Base.Name != "" {
+		t.Error("Expected outer field to take precedence", dest)
+	} else if dest.Version != 2 {
+		t.Error("Expected nested embedded field", dest)
+	}
+}
+
+func Test_Unmarshall_015(t *testing.T) {
+	type Base struct {
+		ID int `yaml:"id"`
+	}
+	var dest struct {
+		*Base
+		Other int `yaml:"other"`
+	}
+	var src = map[string]interface{}{
+		"other": int(1),
+	}
+	if err := marshaler.UnmarshalStruct(src, &dest, "yaml", nil); err != nil {
+		t.Fatal(err)
+	} else if dest.Base != nil {
+		t.Error("Expected embedded pointer to be nil", dest)
+	} else if dest.Other != 1 {
+		t.Error("Unexpected value", dest)
+	}
+}
+
+func Test_Unmarshall_016(t *testing.T) {
+	var dest struct {
+		Host    string        `yaml:"host,required"`
+		Port    int           `yaml:"port,default=8080"`
+		Timeout time.Duration `yaml:"timeout,default=30s"`
+		Debug   bool          `yaml:"debug,default=true"`
+	}
+	if err := marshaler.UnmarshalStruct(map[string]interface{}{}, &dest, "yaml", marshaler.ConvertStringToNumber); err == nil {
+		t.Fatal("Expected error")
+	} else if !strings.Contains(err.Error(), "host") {
+		t.Error("Unexpected error", err)
+	}
+	dec := marshaler.NewDecoder("yaml", marshaler.ConvertDuration, marshaler.ConvertStringToNumber)
+	if err := dec.Decode(map[string]interface{}{"host": "a", "debug": false}, &dest); err != nil {
+		t.Fatal(err)
+	} else if dest.Host != "a" || dest.Port != 8080 || dest.Timeout != 30*time.Second || dest.Debug != false {
+		t.Error("Unexpected value", dest)
+	}
+}
+
+func Test_Unmarshall_017(t *testing.T) {
+	// Defaults are the rest of the tag, and are split on commas for slices
+	var dest struct {
+		Tags  []string  `yaml:"tags,default=a, b"`
+		Ports *[]uint16 `yaml:"ports,omitempty,default=80,443"`
+		Name  string    `yaml:"name,default=a,b"`
+		Empty []string  `yaml:"empty,default="`
+	}
+	dec := marshaler.NewDecoder("yaml", marshaler.ConvertStringToNumber)
+	if err := dec.Decode(map[string]interface{}{}, &dest); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(dest.Tags, []string{"a", "b"}) || dest.Ports == nil || !reflect.DeepEqual(*dest.Ports, []uint16{80, 443}) || dest.Name != "a,b" || dest.Empty == nil || len(dest.Empty) != 0 {
+		t.Errorf("Unexpected %+v", dest)
+	}
+	if fields := marshaler.NewEncoder("yaml").Reflect(dest); !fields[1].HasTag("omitempty") || !reflect.DeepEqual(fields[2].Tags, []string{"default=a,b"}) {
+		t.Error("Unexpected fields", fields[1], fields[2])
+	}
+}